@@ -0,0 +1,80 @@
+package plugin_simplecache
+
+import (
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+// adaptiveMaxKeys bounds the number of keys whose history is tracked. The
+// history is forgotten when it is reached, which only resets the TTLs.
+const adaptiveMaxKeys = 10000
+
+// adaptiveTTL scales the expiry of the responses of a key with their history.
+// A response stored again with the same body after being served from the cache
+// at least once doubles the expiry of the previous one, a changed body halves
+// it. The expiry never drops below the one of the response itself, nor exceeds
+// maxExpiry.
+type adaptiveTTL struct {
+	mu   sync.Mutex
+	keys map[string]*adaptiveKey
+}
+
+type adaptiveKey struct {
+	hits     uint64
+	checksum uint32
+	factor   time.Duration
+}
+
+func newAdaptiveTTL() *adaptiveTTL {
+	return &adaptiveTTL{keys: make(map[string]*adaptiveKey)}
+}
+
+// hit records that the response stored for key was served.
+func (a *adaptiveTTL) hit(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if k, ok := a.keys[key]; ok {
+		k.hits++
+	}
+}
+
+// scale returns the expiry of the response with body stored for key, given the
+// expiry of the response itself.
+func (a *adaptiveTTL) scale(key string, body []byte, expiry, maxExpiry time.Duration) time.Duration {
+	checksum := crc32.ChecksumIEEE(body)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k, ok := a.keys[key]
+	if !ok {
+		if len(a.keys) >= adaptiveMaxKeys {
+			a.keys = make(map[string]*adaptiveKey)
+		}
+		a.keys[key] = &adaptiveKey{checksum: checksum, factor: 1}
+		return expiry
+	}
+
+	switch {
+	case k.checksum != checksum:
+		if k.factor > 1 {
+			k.factor /= 2
+		}
+	case k.hits > 0 && expiry*k.factor < maxExpiry:
+		k.factor *= 2
+	}
+	k.hits = 0
+	k.checksum = checksum
+
+	scaled := expiry * k.factor
+	if scaled > maxExpiry {
+		scaled = maxExpiry
+	}
+	if scaled < expiry {
+		scaled = expiry
+	}
+
+	return scaled
+}
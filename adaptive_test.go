@@ -0,0 +1,89 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveTTL_Scale(t *testing.T) {
+	a := newAdaptiveTTL()
+
+	steps := []struct {
+		hits int
+		body string
+		want time.Duration
+	}{
+		{hits: 0, body: "v1", want: 10 * time.Second},
+		{hits: 0, body: "v1", want: 10 * time.Second},
+		{hits: 3, body: "v1", want: 20 * time.Second},
+		{hits: 1, body: "v1", want: 40 * time.Second},
+		{hits: 1, body: "v1", want: 50 * time.Second},
+		{hits: 1, body: "v1", want: 50 * time.Second},
+		{hits: 1, body: "v2", want: 40 * time.Second},
+		{hits: 0, body: "v3", want: 20 * time.Second},
+		{hits: 0, body: "v4", want: 10 * time.Second},
+		{hits: 0, body: "v5", want: 10 * time.Second},
+	}
+
+	for i, step := range steps {
+		for j := 0; j < step.hits; j++ {
+			a.hit("key")
+		}
+
+		if got := a.scale("key", []byte(step.body), 10*time.Second, 50*time.Second); got != step.want {
+			t.Errorf("unexpected expiry at step %d: want %s, got %s", i, step.want, got)
+		}
+	}
+}
+
+func TestCache_ServeHTTPAdaptiveTTL(t *testing.T) {
+	body := "v1"
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=10")
+		_, _ = rw.Write([]byte(body))
+	}
+
+	cfg := &Config{MaxExpiry: 80, Cleanup: 200, Backend: memoryBackend, AdaptiveTTL: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	clk := newFakeClock()
+	setClock(c, clk)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	storedTTL := func() time.Duration {
+		// The first request stores the response, the second one is a hit.
+		for i := 0; i < 2; i++ {
+			c.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		data, err := c.get(c.cacheKey(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ttl := data.Expires.Sub(data.Stored)
+		clk.Advance(ttl)
+
+		return ttl
+	}
+
+	for _, want := range []time.Duration{10, 20, 40, 80, 80} {
+		if ttl := storedTTL(); ttl != want*time.Second {
+			t.Errorf("unexpected stored ttl: want %s, got %s", want*time.Second, ttl)
+		}
+	}
+
+	body = "v2"
+	if ttl := storedTTL(); ttl != 40*time.Second {
+		t.Errorf("unexpected stored ttl after a change: want %s, got %s", 40*time.Second, ttl)
+	}
+}
@@ -0,0 +1,55 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+// Responses to authorized requests are keyed in two phases when they may be
+// shared. The Authorization header is left out of the request key, so a
+// response that a shared cache may reuse for other requests is stored once for
+// all credentials. Any other one is stored as if it varied on Authorization: a
+// vary manifest takes the place of the shared entry, and its variants are
+// selected by the credentials of each request. This holds whatever set the
+// TTL of the response, including the overrides of Cache-Control.
+
+// sharedAuthorized reports whether the response headers h allow a shared cache
+// to reuse the response to an authorized request for other requests, see RFC
+// 9111 section 3.5.
+func sharedAuthorized(h http.Header) bool {
+	dir, err := cacheobject.ParseResponseCacheControl(h.Get("Cache-Control"))
+	return err == nil && !dir.PrivatePresent && (dir.Public || dir.SMaxAge != -1 || dir.MustRevalidate)
+}
+
+// perCredentials reports whether the response with headers h to r is only
+// stored for the credentials of r.
+func (m *cache) perCredentials(r *http.Request, h http.Header) bool {
+	return m.cfg.ShareAuthorized && r.Header.Get("Authorization") != "" && !sharedAuthorized(h)
+}
+
+// privateAuthorized reports whether the response headers h make the response
+// to the authorized request r private to its credentials, which a shared cache
+// would not store otherwise.
+func (m *cache) privateAuthorized(r *http.Request, h http.Header) bool {
+	if !m.perCredentials(r, h) {
+		return false
+	}
+
+	dir, err := cacheobject.ParseResponseCacheControl(h.Get("Cache-Control"))
+	return err == nil && dir.PrivatePresent
+}
+
+// withoutPrivateReasons returns the reasons not to store a response, except
+// those forbidding a shared cache to store a private response to an authorized
+// request, which is only stored for its credentials.
+func withoutPrivateReasons(reasons []cacheobject.Reason) []cacheobject.Reason {
+	var rv []cacheobject.Reason
+	for _, reason := range reasons {
+		if reason != cacheobject.ReasonResponsePrivate && reason != cacheobject.ReasonRequestAuthorizationHeader {
+			rv = append(rv, reason)
+		}
+	}
+
+	return rv
+}
@@ -0,0 +1,187 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache_ServeHTTPShareAuthorized(t *testing.T) {
+	tests := []struct {
+		name         string
+		share        bool
+		cacheControl string
+		wantBodies   []string
+		wantCalls    int
+	}{
+		{
+			name:         "should share a public response across credentials",
+			share:        true,
+			cacheControl: "public, max-age=20",
+			wantBodies:   []string{"for token-a", "for token-a", "for token-a", "for token-a"},
+			wantCalls:    1,
+		},
+		{
+			name:         "should store a private response per credentials",
+			share:        true,
+			cacheControl: "private, max-age=20",
+			wantBodies:   []string{"for token-a", "for token-a", "for token-b", "for token-b"},
+			wantCalls:    2,
+		},
+		{
+			name:         "should not store a response to authorized requests without public or private",
+			share:        true,
+			cacheControl: "max-age=20",
+			wantBodies:   []string{"for token-a", "for token-a", "for token-b", "for token-b"},
+			wantCalls:    4,
+		},
+		{
+			name:         "should key a public response per credentials without sharing",
+			cacheControl: "public, max-age=20",
+			wantBodies:   []string{"for token-a", "for token-a", "for token-b", "for token-b"},
+			wantCalls:    2,
+		},
+		{
+			name:         "should not store a private response without sharing",
+			cacheControl: "private, max-age=20",
+			wantBodies:   []string{"for token-a", "for token-a", "for token-b", "for token-b"},
+			wantCalls:    4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", test.cacheControl)
+				_, _ = rw.Write([]byte("for " + req.Header.Get("Authorization")))
+			}
+
+			cfg := &Config{
+				MaxExpiry:       100,
+				Cleanup:         200,
+				Backend:         memoryBackend,
+				VaryByHeaders:   []string{"Authorization"},
+				ShareAuthorized: test.share,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, auth := range []string{"token-a", "token-a", "token-b", "token-b"} {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+				req.Header.Set("Authorization", auth)
+
+				rw := httptest.NewRecorder()
+				h.ServeHTTP(rw, req)
+
+				if body := rw.Body.String(); body != test.wantBodies[i] {
+					t.Errorf("unexpected body for request %d with %q: want %q, got %q", i, auth, test.wantBodies[i], body)
+				}
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPShareAuthorizedOverrides(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		header http.Header
+		status int
+	}{
+		{
+			name:   "should key a TTL override per credentials",
+			cfg:    Config{TTLOverrideHeader: "X-Cache-TTL"},
+			header: http.Header{"X-Cache-Ttl": {"20"}},
+		},
+		{
+			name:   "should key a Surrogate-Control TTL per credentials",
+			cfg:    Config{HonorSurrogateControl: true},
+			header: http.Header{"Surrogate-Control": {"max-age=20"}},
+		},
+		{
+			name:   "should key a status TTL override per credentials",
+			cfg:    Config{StatusTTLOverrides: map[int]int{http.StatusOK: 20}},
+			header: http.Header{},
+		},
+		{
+			name:   "should key a Retry-After TTL per credentials",
+			cfg:    Config{CacheRetryAfter: true},
+			header: http.Header{"Retry-After": {"20"}},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:   "should key a forced response per credentials",
+			cfg:    Config{ForceCache: true},
+			header: http.Header{"Cache-Control": {"no-cache"}},
+		},
+		{
+			name:   "should key a forced private response per credentials",
+			cfg:    Config{ForceCache: true},
+			header: http.Header{"Cache-Control": {"private, max-age=20"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				for name, vals := range test.header {
+					rw.Header()[name] = vals
+				}
+				if test.status != 0 {
+					rw.WriteHeader(test.status)
+				}
+				_, _ = rw.Write([]byte("secret for " + req.Header.Get("Authorization")))
+			}
+
+			cfg := test.cfg
+			cfg.MaxExpiry = 100
+			cfg.Cleanup = 200
+			cfg.Backend = memoryBackend
+			cfg.ShareAuthorized = true
+
+			h, err := New(context.Background(), http.HandlerFunc(next), &cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			requests := []struct {
+				auth      string
+				wantCalls int
+			}{
+				{auth: "Bearer alice", wantCalls: 1},
+				{auth: "Bearer alice", wantCalls: 1},
+				{auth: "Bearer bob", wantCalls: 2},
+				{auth: "", wantCalls: 3},
+			}
+
+			for _, r := range requests {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+				if r.auth != "" {
+					req.Header.Set("Authorization", r.auth)
+				}
+
+				rw := httptest.NewRecorder()
+				h.ServeHTTP(rw, req)
+
+				if want := "secret for " + r.auth; rw.Body.String() != want {
+					t.Errorf("unexpected body for %q: want %q, got %q", r.auth, want, rw.Body.String())
+				}
+				if calls != r.wantCalls {
+					t.Errorf("unexpected next handler calls for %q: want %d, got %d", r.auth, r.wantCalls, calls)
+				}
+			}
+		})
+	}
+}
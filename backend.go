@@ -0,0 +1,136 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	fileBackend   = "file"
+	memoryBackend = "memory"
+	redisBackend  = "redis"
+)
+
+// backend stores cache entries. Get and Delete return errCacheMiss when there is
+// no entry for the key.
+type backend interface {
+	Get(key string) ([]byte, error)
+	Set(key string, val []byte, expiry time.Duration) error
+	Delete(key string) error
+
+	// Clear removes all entries.
+	Clear() error
+
+	// Evictions returns the number of entries removed by the backend itself.
+	Evictions() uint64
+}
+
+// expirer is implemented by backends whose expired entries must be removed
+// periodically.
+type expirer interface {
+	removeExpired()
+}
+
+// streamer is implemented by backends that can read an entry without loading it
+// into memory. It returns errCacheMiss like Get.
+type streamer interface {
+	open(key string) (io.ReadCloser, error)
+}
+
+// sizer is implemented by backends that can report how many entries, and bytes,
+// they currently hold.
+type sizer interface {
+	usage() (entries int, bytes int64)
+}
+
+// lister is implemented by backends that can enumerate the responses they
+// hold.
+type lister interface {
+	entries() ([]entryInfo, error)
+}
+
+// checker is implemented by backends that can check they are usable, such as
+// a writable directory or a reachable server.
+type checker interface {
+	check() error
+}
+
+// newBackend returns the configured backend.
+func newBackend(cfg *Config) (backend, error) {
+	switch cfg.Backend {
+	case "", fileBackend:
+		fc, err := newFileCache(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		fc.compress = cfg.CompressOnDisk
+		fc.compressMinBytes = cfg.CompressMinBytes
+		fc.maxBytes = cfg.MaxDiskBytes
+		fc.maxEntries = cfg.MaxEntries
+		if fc.dirMode, err = parseMode(cfg.DirMode, defaultDirMode); err != nil {
+			return nil, fmt.Errorf("invalid dirMode: %w", err)
+		}
+		if fc.fileMode, err = parseMode(cfg.FileMode, defaultFileMode); err != nil {
+			return nil, fmt.Errorf("invalid fileMode: %w", err)
+		}
+		return fc, nil
+	case memoryBackend:
+		return newMemoryCache(cfg.MaxMemoryBytes), nil
+	case redisBackend:
+		if cfg.RedisAddr == "" {
+			return nil, errors.New("redisAddr must be set")
+		}
+		return newRedisCache(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
+// parseMode parses an octal permission mode such as "0750", falling back to def
+// when s is empty.
+func parseMode(s string, def os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode&^uint64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("%q is not an octal permission mode", s)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// runCleanup removes the expired entries of e every interval until ctx is done.
+func runCleanup(ctx context.Context, e expirer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.removeExpired()
+		}
+	}
+}
+
+// evictionCounter counts the entries a backend removes, accessed atomically.
+type evictionCounter struct {
+	evictions uint64
+}
+
+func (c *evictionCounter) evicted() {
+	atomic.AddUint64(&c.evictions, 1)
+}
+
+func (c *evictionCounter) Evictions() uint64 {
+	return atomic.LoadUint64(&c.evictions)
+}
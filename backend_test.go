@@ -0,0 +1,334 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackends(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(t *testing.T, clk clock) backend
+	}{
+		{
+			name: "file",
+			backend: func(t *testing.T, clk clock) backend {
+				fc, err := newFileCache(createTempDir(t))
+				if err != nil {
+					t.Fatal(err)
+				}
+				fc.clock = clk
+				return fc
+			},
+		},
+		{
+			name: "memory",
+			backend: func(t *testing.T, clk clock) backend {
+				mc := newMemoryCache(1 << 20)
+				mc.clock = clk
+				return mc
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clk := newFakeClock()
+			b := test.backend(t, clk)
+
+			testBackend(t, b, clk.Advance)
+		})
+	}
+}
+
+// testBackend checks that b satisfies the backend contract. advance moves the
+// clock used by b forward.
+func testBackend(t *testing.T, b backend, advance func(time.Duration)) {
+	t.Helper()
+
+	content := []byte("some random cache content that should be exact")
+
+	if _, err := b.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected get error on empty backend: want %v, got %v", errCacheMiss, err)
+	}
+
+	if err := b.Set(testCacheKey, content, 10*time.Second); err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+
+	got, err := b.Get(testCacheKey)
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("unexpected content: want %q, got %q", content, got)
+	}
+
+	if err = b.Delete(testCacheKey); err != nil {
+		t.Errorf("unexpected delete error: %v", err)
+	}
+	if _, err = b.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected get error after delete: want %v, got %v", errCacheMiss, err)
+	}
+	if err = b.Delete(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected delete error on missing entry: want %v, got %v", errCacheMiss, err)
+	}
+
+	if err = b.Set(testCacheKey, content, 10*time.Second); err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+
+	advance(10 * time.Second)
+
+	if _, err = b.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected get error after expiry: want %v, got %v", errCacheMiss, err)
+	}
+	if n := b.Evictions(); n != 1 {
+		t.Errorf("unexpected evictions: want 1, got %d", n)
+	}
+
+	keys := []string{"key0", "key1", "key2"}
+	for _, key := range keys {
+		if err = b.Set(key, content, 10*time.Second); err != nil {
+			t.Fatalf("unexpected set error: %v", err)
+		}
+	}
+
+	if err = b.Clear(); err != nil {
+		t.Fatalf("unexpected clear error: %v", err)
+	}
+	for _, key := range keys {
+		if _, err = b.Get(key); !errors.Is(err, errCacheMiss) {
+			t.Errorf("unexpected get error for %q after clear: want %v, got %v", key, errCacheMiss, err)
+		}
+	}
+}
+
+// errorBackend is a backend whose reads or writes fail with the given errors.
+type errorBackend struct {
+	backend
+	getErr, setErr error
+}
+
+func (b *errorBackend) Get(key string) ([]byte, error) {
+	if b.getErr != nil {
+		return nil, b.getErr
+	}
+	return b.backend.Get(key)
+}
+
+func (b *errorBackend) Set(key string, val []byte, expiry time.Duration) error {
+	if b.setErr != nil {
+		return b.setErr
+	}
+	return b.backend.Set(key, val, expiry)
+}
+
+func TestCache_ServeHTTPBackendErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		onCacheError string
+		getErr       error
+		setErr       error
+		wantCode     int
+		wantCalls    int
+		wantStatus   string
+		wantStats    statsSnapshot
+	}{
+		{
+			name:       "should serve from the cache without errors",
+			wantCalls:  1,
+			wantStatus: "simplecache; hit; ttl=20",
+			wantStats:  statsSnapshot{Hits: 1, Misses: 1, Sets: 1},
+		},
+		{
+			name:       "should fall back to the origin when reads fail",
+			getErr:     errors.New("connection refused"),
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=miss; stored; detail=error",
+			wantStats:  statsSnapshot{Errors: 2, Sets: 2},
+		},
+		{
+			name:         "should fail when reads fail",
+			onCacheError: onCacheErrorFail,
+			getErr:       errors.New("permission denied"),
+			wantCode:     http.StatusInternalServerError,
+			wantCalls:    0,
+			wantStatus:   "simplecache; fwd=miss; detail=error",
+			wantStats:    statsSnapshot{Errors: 2},
+		},
+		{
+			name:         "should forward misses when failing on errors",
+			onCacheError: onCacheErrorFail,
+			wantCalls:    1,
+			wantStatus:   "simplecache; hit; ttl=20",
+			wantStats:    statsSnapshot{Hits: 1, Misses: 1, Sets: 1},
+		},
+		{
+			name:       "should serve the origin response when writes fail",
+			setErr:     errors.New("no space left on device"),
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=miss; stored",
+			wantStats:  statsSnapshot{Misses: 2, Errors: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				_, _ = rw.Write([]byte("some body"))
+			}
+
+			cfg := &Config{MaxExpiry: 100, Cleanup: cleanupDisabled, AddStatusHeader: true, OnCacheError: test.onCacheError}
+			b := &errorBackend{backend: newMemoryCache(0), getErr: test.getErr, setErr: test.setErr}
+
+			c, err := newCache(context.Background(), http.HandlerFunc(next), cfg, "simplecache", b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rw *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+				if test.wantCode != 0 {
+					if rw.Code != test.wantCode {
+						t.Errorf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+					}
+					continue
+				}
+				if rw.Code != http.StatusOK || rw.Body.String() != "some body" {
+					t.Errorf("unexpected response: got status %d and body %q", rw.Code, rw.Body.String())
+				}
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if status := rw.Header().Get(cacheHeader); status != test.wantStatus {
+				t.Errorf("unexpected cache status: want %q, got %q", test.wantStatus, status)
+			}
+			if stats := c.Stats(); stats != test.wantStats {
+				t.Errorf("unexpected stats: want %+v, got %+v", test.wantStats, stats)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPCorruptedEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+	}{
+		{name: "should remove a corrupted file entry", backend: fileBackend},
+		{name: "should remove a corrupted memory entry", backend: memoryBackend},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cacheControl := "no-store"
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", cacheControl)
+				_, _ = rw.Write([]byte("some body"))
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 100, Cleanup: cleanupDisabled, Backend: test.backend, AddStatusHeader: true}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			key := c.cacheKey(req)
+
+			// The backend stores the garbage as is, only unmarshaling it fails.
+			if err = c.cache.Set(key, []byte("\x00\x00\x00\x05garbage"), time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if want := "simplecache; fwd=miss; detail=error"; rw.Header().Get(cacheHeader) != want {
+				t.Errorf("unexpected cache status: want %q, got %q", want, rw.Header().Get(cacheHeader))
+			}
+			if _, err = c.cache.Get(key); !errors.Is(err, errCacheMiss) {
+				t.Errorf("unexpected error reading the corrupted entry: want %v, got %v", errCacheMiss, err)
+			}
+
+			cacheControl = "max-age=20"
+			for _, want := range []string{"simplecache; fwd=miss; stored", "simplecache; hit; ttl=20"} {
+				rw = httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+
+				if status := rw.Header().Get(cacheHeader); status != want {
+					t.Errorf("unexpected cache status: want %q, got %q", want, status)
+				}
+				if rw.Body.String() != "some body" {
+					t.Errorf("unexpected body: %q", rw.Body.String())
+				}
+			}
+
+			if calls != 2 {
+				t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+			}
+			if stats := c.Stats(); stats.Errors != 1 {
+				t.Errorf("unexpected errors: want 1, got %d", stats.Errors)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPLegacyJSONEntry(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		_, _ = rw.Write([]byte("origin body"))
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: cleanupDisabled, Backend: memoryBackend}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	key := c.cacheKey(req)
+
+	// Backends such as Redis keep the entries written as JSON by older versions.
+	legacy := []byte(`{"Status":200,"Headers":{"Content-Type":["text/plain"]},"Body":"c29tZSBib2R5"}`)
+	if err = c.cache.Set(key, legacy, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if rw.Body.String() != "some body" {
+		t.Errorf("unexpected body: want %q, got %q", "some body", rw.Body.String())
+	}
+	if rw.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected content type: %q", rw.Header().Get("Content-Type"))
+	}
+	if calls != 0 {
+		t.Errorf("unexpected next handler calls: want 0, got %d", calls)
+	}
+	if stats := c.Stats(); stats.Errors != 0 || stats.Hits != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,57 @@
+package plugin_simplecache
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	minWriteBackoff = time.Second
+	maxWriteBackoff = time.Minute
+)
+
+// writeBackoff suspends writes to a failing backend, such as a full disk, so
+// every response does not fail and log again. The suspension doubles on each
+// consecutive failure.
+type writeBackoff struct {
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+}
+
+// suspended reports whether writes are suspended at now.
+func (b *writeBackoff) suspended(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return now.Before(b.until)
+}
+
+// failed records a failed write at now and returns how long writes are
+// suspended for.
+func (b *writeBackoff) failed(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d := minWriteBackoff
+	for i := 0; i < b.failures && d < maxWriteBackoff; i++ {
+		d *= 2
+	}
+	if d > maxWriteBackoff {
+		d = maxWriteBackoff
+	}
+
+	b.failures++
+	b.until = now.Add(d)
+
+	return d
+}
+
+// succeeded records a successful write, resetting the suspension.
+func (b *writeBackoff) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.until = time.Time{}
+}
@@ -2,184 +2,1343 @@
 package plugin_simplecache
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
-	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
-	"github.com/pquerna/cachecontrol"
+	"github.com/pquerna/cachecontrol/cacheobject"
 )
 
 // Config configures the middleware.
 type Config struct {
-	Path            string `json:"path" yaml:"path" toml:"path"`
-	MaxExpiry       int    `json:"maxExpiry" yaml:"maxExpiry" toml:"maxExpiry"`
-	Cleanup         int    `json:"cleanup" yaml:"cleanup" toml:"cleanup"`
-	AddStatusHeader bool   `json:"addStatusHeader" yaml:"addStatusHeader" toml:"addStatusHeader"`
+	Path                   string      `json:"path" yaml:"path" toml:"path"`
+	MaxExpiry              int         `json:"maxExpiry" yaml:"maxExpiry" toml:"maxExpiry"`
+	Cleanup                int         `json:"cleanup" yaml:"cleanup" toml:"cleanup"`
+	AddStatusHeader        bool        `json:"addStatusHeader" yaml:"addStatusHeader" toml:"addStatusHeader"`
+	Debug                  bool        `json:"debug" yaml:"debug" toml:"debug"`
+	CacheMethods           []string    `json:"cacheMethods" yaml:"cacheMethods" toml:"cacheMethods"`
+	ForceCache             bool        `json:"forceCache" yaml:"forceCache" toml:"forceCache"`
+	Revalidate             bool        `json:"revalidate" yaml:"revalidate" toml:"revalidate"`
+	MaxBodyBytes           int64       `json:"maxBodyBytes" yaml:"maxBodyBytes" toml:"maxBodyBytes"`
+	MinBodyBytes           int64       `json:"minBodyBytes" yaml:"minBodyBytes" toml:"minBodyBytes"`
+	StaleWhileRevalidate   int         `json:"staleWhileRevalidate" yaml:"staleWhileRevalidate" toml:"staleWhileRevalidate"`
+	VaryByHeaders          []string    `json:"varyByHeaders" yaml:"varyByHeaders" toml:"varyByHeaders"`
+	IgnoreQueryParams      []string    `json:"ignoreQueryParams" yaml:"ignoreQueryParams" toml:"ignoreQueryParams"`
+	SortQueryParams        bool        `json:"sortQueryParams" yaml:"sortQueryParams" toml:"sortQueryParams"`
+	Backend                string      `json:"backend" yaml:"backend" toml:"backend"`
+	MaxMemoryBytes         int64       `json:"maxMemoryBytes" yaml:"maxMemoryBytes" toml:"maxMemoryBytes"`
+	CompressOnDisk         bool        `json:"compressOnDisk" yaml:"compressOnDisk" toml:"compressOnDisk"`
+	LegacyStatusHeader     bool        `json:"legacyStatusHeader" yaml:"legacyStatusHeader" toml:"legacyStatusHeader"`
+	CacheWithSetCookie     bool        `json:"cacheWithSetCookie" yaml:"cacheWithSetCookie" toml:"cacheWithSetCookie"`
+	MaxDiskBytes           int64       `json:"maxDiskBytes" yaml:"maxDiskBytes" toml:"maxDiskBytes"`
+	ServeStaleOnError      bool        `json:"serveStaleOnError" yaml:"serveStaleOnError" toml:"serveStaleOnError"`
+	MaxStaleOnError        int         `json:"maxStaleOnError" yaml:"maxStaleOnError" toml:"maxStaleOnError"`
+	MetricsPath            string      `json:"metricsPath" yaml:"metricsPath" toml:"metricsPath"`
+	CacheableStatusCodes   []int       `json:"cacheableStatusCodes" yaml:"cacheableStatusCodes" toml:"cacheableStatusCodes"`
+	PurgeAuthToken         string      `json:"purgeAuthToken" yaml:"purgeAuthToken" toml:"purgeAuthToken"`
+	NegativeTTL            int         `json:"negativeTtl" yaml:"negativeTtl" toml:"negativeTtl"`
+	DirMode                string      `json:"dirMode" yaml:"dirMode" toml:"dirMode"`
+	FileMode               string      `json:"fileMode" yaml:"fileMode" toml:"fileMode"`
+	RedisAddr              string      `json:"redisAddr" yaml:"redisAddr" toml:"redisAddr"`
+	RedisDB                int         `json:"redisDb" yaml:"redisDb" toml:"redisDb"`
+	RedisPassword          string      `json:"redisPassword" yaml:"redisPassword" toml:"redisPassword"`
+	KeyTemplate            string      `json:"keyTemplate" yaml:"keyTemplate" toml:"keyTemplate"`
+	LogLevel               string      `json:"logLevel" yaml:"logLevel" toml:"logLevel"`
+	WarmupURLs             []string    `json:"warmupUrls" yaml:"warmupUrls" toml:"warmupUrls"`
+	ExpiryJitter           int         `json:"expiryJitter" yaml:"expiryJitter" toml:"expiryJitter"`
+	NoCachePaths           []string    `json:"noCachePaths" yaml:"noCachePaths" toml:"noCachePaths"`
+	CachePathsOnly         []string    `json:"cachePathsOnly" yaml:"cachePathsOnly" toml:"cachePathsOnly"`
+	TTLOverrideHeader      string      `json:"ttlOverrideHeader" yaml:"ttlOverrideHeader" toml:"ttlOverrideHeader"`
+	NoCacheHeader          string      `json:"noCacheHeader" yaml:"noCacheHeader" toml:"noCacheHeader"`
+	HonorSurrogateControl  bool        `json:"honorSurrogateControl" yaml:"honorSurrogateControl" toml:"honorSurrogateControl"`
+	CachePostBody          bool        `json:"cachePostBody" yaml:"cachePostBody" toml:"cachePostBody"`
+	PostBodyPaths          []string    `json:"postBodyPaths" yaml:"postBodyPaths" toml:"postBodyPaths"`
+	ShadowMode             bool        `json:"shadowMode" yaml:"shadowMode" toml:"shadowMode"`
+	MaxEntries             int         `json:"maxEntries" yaml:"maxEntries" toml:"maxEntries"`
+	CacheContentTypes      []string    `json:"cacheContentTypes" yaml:"cacheContentTypes" toml:"cacheContentTypes"`
+	NoCacheContentTypes    []string    `json:"noCacheContentTypes" yaml:"noCacheContentTypes" toml:"noCacheContentTypes"`
+	StatusHeaderName       string      `json:"statusHeaderName" yaml:"statusHeaderName" toml:"statusHeaderName"`
+	UppercaseStatusHeader  bool        `json:"uppercaseStatusHeader" yaml:"uppercaseStatusHeader" toml:"uppercaseStatusHeader"`
+	NormalizeTrailingSlash bool        `json:"normalizeTrailingSlash" yaml:"normalizeTrailingSlash" toml:"normalizeTrailingSlash"`
+	LowercasePath          bool        `json:"lowercasePath" yaml:"lowercasePath" toml:"lowercasePath"`
+	EntriesPath            string      `json:"entriesPath" yaml:"entriesPath" toml:"entriesPath"`
+	CacheRetryAfter        bool        `json:"cacheRetryAfter" yaml:"cacheRetryAfter" toml:"cacheRetryAfter"`
+	WriteOnly              bool        `json:"writeOnly" yaml:"writeOnly" toml:"writeOnly"`
+	ReadOnly               bool        `json:"readOnly" yaml:"readOnly" toml:"readOnly"`
+	MaxVariants            int         `json:"maxVariants" yaml:"maxVariants" toml:"maxVariants"`
+	PersistStats           bool        `json:"persistStats" yaml:"persistStats" toml:"persistStats"`
+	TenantHeader           string      `json:"tenantHeader" yaml:"tenantHeader" toml:"tenantHeader"`
+	IncludeScheme          bool        `json:"includeScheme" yaml:"includeScheme" toml:"includeScheme"`
+	IncludePort            bool        `json:"includePort" yaml:"includePort" toml:"includePort"`
+	CompressResponses      bool        `json:"compressResponses" yaml:"compressResponses" toml:"compressResponses"`
+	OriginTimeout          int         `json:"originTimeout" yaml:"originTimeout" toml:"originTimeout"`
+	OnCacheError           string      `json:"onCacheError" yaml:"onCacheError" toml:"onCacheError"`
+	KeyPrefix              string      `json:"keyPrefix" yaml:"keyPrefix" toml:"keyPrefix"`
+	HealthPath             string      `json:"healthPath" yaml:"healthPath" toml:"healthPath"`
+	AdaptiveTTL            bool        `json:"adaptiveTtl" yaml:"adaptiveTtl" toml:"adaptiveTtl"`
+	CompressMinBytes       int64       `json:"compressMinBytes" yaml:"compressMinBytes" toml:"compressMinBytes"`
+	StripHeaders           []string    `json:"stripHeaders" yaml:"stripHeaders" toml:"stripHeaders"`
+	KeepHeaders            []string    `json:"keepHeaders" yaml:"keepHeaders" toml:"keepHeaders"`
+	VaryByCookies          []string    `json:"varyByCookies" yaml:"varyByCookies" toml:"varyByCookies"`
+	MaxKeyLength           int         `json:"maxKeyLength" yaml:"maxKeyLength" toml:"maxKeyLength"`
+	ShareAuthorized        bool        `json:"shareAuthorized" yaml:"shareAuthorized" toml:"shareAuthorized"`
+	BodyTransforms         []string    `json:"bodyTransforms" yaml:"bodyTransforms" toml:"bodyTransforms"`
+	StatusTTLOverrides     map[int]int `json:"statusTtlOverrides" yaml:"statusTtlOverrides" toml:"statusTtlOverrides"`
 }
 
 // CreateConfig returns a config instance.
 func CreateConfig() *Config {
 	return &Config{
-		MaxExpiry:       int((5 * time.Minute).Seconds()),
-		Cleanup:         int((5 * time.Minute).Seconds()),
-		AddStatusHeader: true,
+		MaxExpiry:        int((5 * time.Minute).Seconds()),
+		Cleanup:          int((5 * time.Minute).Seconds()),
+		AddStatusHeader:  true,
+		StatusHeaderName: cacheHeader,
+		CacheMethods:     []string{http.MethodGet, http.MethodHead},
+		VaryByHeaders:    []string{"Authorization"},
+		Backend:          fileBackend,
+		MaxMemoryBytes:   64 << 20,
+		CacheableStatusCodes: []int{
+			http.StatusOK, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone,
+		},
 	}
 }
 
 const (
-	cacheHeader      = "Cache-Status"
-	cacheHitStatus   = "hit"
-	cacheMissStatus  = "miss"
-	cacheErrorStatus = "error"
-	cleanupDisabled  = -1
+	cacheHeader       = "Cache-Status"
+	cacheHitStatus    = "hit"
+	cacheMissStatus   = "miss"
+	cacheErrorStatus  = "error"
+	cacheBypassStatus = "bypass"
+	cleanupDisabled   = -1
+
+	onCacheErrorBypass = "bypass"
+	onCacheErrorFail   = "fail"
 )
 
 type cache struct {
-	name  string
-	cache *fileCache
-	cfg   *Config
-	next  http.Handler
+	name      string
+	cache     backend
+	cfg       *Config
+	methods   map[string]struct{}
+	codes     map[int]struct{}
+	headers   []string
+	cookies   []string
+	rewrites  []bodyTransform
+	kept      map[string]struct{}
+	ignored   map[string]struct{}
+	keyTmpl   *template.Template
+	keyPrefix string
+	bypass    []*regexp.Regexp
+	only      []*regexp.Regexp
+	postPaths []*regexp.Regexp
+	types     map[string]struct{}
+	noTypes   map[string]struct{}
+	flight    *flightGroup
+	clock     clock
+	stats     *cacheStats
+	backoff   writeBackoff
+	adaptive  *adaptiveTTL
+	logger    *slog.Logger
+	next      http.Handler
+
+	// rng draws the expiry jitter, guarded by rngMu.
+	rngMu sync.Mutex
+	rng   *rand.Rand
+
+	// cancel stops the background goroutines, tracked by wg, which run with
+	// ctx. Once closed is set under closeMu, no goroutine is added to wg.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeMu   sync.Mutex
+	closed    bool
+	closeOnce sync.Once
 }
 
 // New returns a plugin instance.
-func New(_ context.Context, next http.Handler, cfg *Config, name string) (http.Handler, error) {
-	if cfg.MaxExpiry <= 1 {
-		return nil, errors.New("maxExpiry must be greater or equal to 1")
+func New(ctx context.Context, next http.Handler, cfg *Config, name string) (http.Handler, error) {
+	if err := validate(cfg); err != nil {
+		return nil, err
 	}
 
-	if cfg.Cleanup <= 1 && cfg.Cleanup != cleanupDisabled {
-		return nil, fmt.Errorf("cleanup must be greater or equal to 1 or disabled %d", cleanupDisabled)
+	b, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newCache(ctx, next, cfg, name, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// newCache returns a plugin instance storing its entries in b, which tests use
+// to inject a backend of their own. The configuration must be valid.
+func newCache(ctx context.Context, next http.Handler, cfg *Config, name string, b backend) (*cache, error) {
+	keyTmpl, err := parseKeyTemplate(cfg.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	bypass, err := compilePaths("noCachePaths", cfg.NoCachePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	only, err := compilePaths("cachePathsOnly", cfg.CachePathsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	postPaths, err := compilePaths("postBodyPaths", cfg.PostBodyPaths)
+	if err != nil {
+		return nil, err
 	}
 
-	fc, err := newFileCache(cfg.Path, time.Duration(cfg.Cleanup)*time.Second)
+	logger, err := newLogger(cfg, name)
 	if err != nil {
 		return nil, err
 	}
 
+	// Counters are persisted next to the entries of the file backend.
+	stats := &cacheStats{}
+	fc, persist := b.(*fileCache)
+	persist = persist && cfg.PersistStats
+	if persist {
+		if stats, err = loadStats(filepath.Join(fc.path, statsFileName)); err != nil {
+			logger.Warn("Error loading statistics, starting from zero", "error", err)
+		}
+	}
+
 	m := &cache{
-		name:  name,
-		cache: fc,
-		cfg:   cfg,
-		next:  next,
+		name:      name,
+		cache:     b,
+		cfg:       cfg,
+		methods:   cacheMethods(cfg.CacheMethods),
+		codes:     cacheableCodes(cfg.CacheableStatusCodes),
+		headers:   keyHeaders(cfg.VaryByHeaders),
+		cookies:   keyCookies(cfg.VaryByCookies),
+		rewrites:  transformsOf(cfg.BodyTransforms),
+		kept:      headerSet(cfg.KeepHeaders),
+		ignored:   ignoredParams(cfg.IgnoreQueryParams),
+		keyTmpl:   keyTmpl,
+		keyPrefix: keyPrefix(cfg, name),
+		bypass:    bypass,
+		only:      only,
+		postPaths: postPaths,
+		types:     mediaTypes(cfg.CacheContentTypes),
+		noTypes:   mediaTypes(cfg.NoCacheContentTypes),
+		flight:    newFlightGroup(),
+		adaptive:  newAdaptiveTTL(),
+		clock:     realClock{},
+		stats:     stats,
+		logger:    logger,
+		next:      next,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 
+	publishStats(m)
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.ctx = ctx
+
+	if e, ok := b.(expirer); ok && cfg.Cleanup != cleanupDisabled {
+		m.goBackground(func() { runCleanup(ctx, e, time.Duration(cfg.Cleanup)*time.Second) })
+	}
+
+	if persist {
+		m.goBackground(func() {
+			m.persistStats(ctx, filepath.Join(fc.path, statsFileName), fc.fileMode, statsPersistInterval)
+		})
+	}
+
+	if len(cfg.WarmupURLs) > 0 {
+		m.goBackground(func() { m.warmup(ctx, cfg.WarmupURLs) })
+	}
+
+	// Traefik cancels the context of the middleware when its configuration is
+	// reloaded.
+	go func() {
+		<-ctx.Done()
+		_ = m.Close()
+	}()
+
 	return m, nil
 }
 
+// goBackground runs fn in a goroutine that Close waits for.
+func (m *cache) goBackground(fn func()) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		fn()
+	}()
+}
+
+// Close stops the background goroutines of the middleware, waits for them to
+// exit and releases the resources of the backend. It is called when the context
+// given to New is done and may be called more than once.
+func (m *cache) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		m.closeMu.Lock()
+		m.closed = true
+		m.closeMu.Unlock()
+
+		m.cancel()
+		m.wg.Wait()
+
+		if c, ok := m.cache.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+
+	return err
+}
+
+// cacheableCodes returns the set of cacheable status codes, falling back to 200,
+// 301, 404 and 410 when none are configured.
+func cacheableCodes(codes []int) map[int]struct{} {
+	if len(codes) == 0 {
+		codes = []int{http.StatusOK, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone}
+	}
+
+	set := make(map[int]struct{}, len(codes))
+	for _, code := range codes {
+		set[code] = struct{}{}
+	}
+
+	return set
+}
+
+// validate checks the numeric options of cfg.
+func validate(cfg *Config) error {
+	if cfg.MaxExpiry <= 1 {
+		return errors.New("maxExpiry must be greater or equal to 1")
+	}
+
+	if cfg.Cleanup <= 1 && cfg.Cleanup != cleanupDisabled {
+		return fmt.Errorf("cleanup must be greater or equal to 1 or disabled %d", cleanupDisabled)
+	}
+
+	if cfg.MaxBodyBytes < 0 {
+		return errors.New("maxBodyBytes must be greater or equal to 0")
+	}
+
+	if cfg.CompressMinBytes < 0 {
+		return errors.New("compressMinBytes must be greater or equal to 0")
+	}
+
+	if cfg.MinBodyBytes < 0 {
+		return errors.New("minBodyBytes must be greater or equal to 0")
+	}
+
+	if cfg.MaxBodyBytes > 0 && cfg.MinBodyBytes > cfg.MaxBodyBytes {
+		return errors.New("minBodyBytes must be lower or equal to maxBodyBytes")
+	}
+
+	if cfg.StaleWhileRevalidate < 0 {
+		return errors.New("staleWhileRevalidate must be greater or equal to 0")
+	}
+
+	if cfg.MaxMemoryBytes < 0 {
+		return errors.New("maxMemoryBytes must be greater or equal to 0")
+	}
+
+	if cfg.MaxDiskBytes < 0 {
+		return errors.New("maxDiskBytes must be greater or equal to 0")
+	}
+
+	for status, secs := range cfg.StatusTTLOverrides {
+		if status < 100 || status > 599 || secs < 0 {
+			return fmt.Errorf("invalid statusTtlOverrides entry %d: %d", status, secs)
+		}
+	}
+
+	if cfg.MaxVariants < 0 {
+		return errors.New("maxVariants must be greater or equal to 0")
+	}
+
+	if cfg.MaxEntries < 0 {
+		return errors.New("maxEntries must be greater or equal to 0")
+	}
+
+	switch cfg.OnCacheError {
+	case "", onCacheErrorBypass, onCacheErrorFail:
+	default:
+		return fmt.Errorf("unknown onCacheError %q", cfg.OnCacheError)
+	}
+
+	if cfg.OriginTimeout < 0 {
+		return errors.New("originTimeout must be greater or equal to 0")
+	}
+
+	if cfg.MaxKeyLength != 0 && cfg.MaxKeyLength < minKeyLength {
+		return fmt.Errorf("maxKeyLength must be 0 or at least %d", minKeyLength)
+	}
+
+	if cfg.MaxStaleOnError < 0 {
+		return errors.New("maxStaleOnError must be greater or equal to 0")
+	}
+
+	if cfg.RedisDB < 0 {
+		return errors.New("redisDb must be greater or equal to 0")
+	}
+
+	if cfg.WriteOnly && cfg.ReadOnly {
+		return errors.New("writeOnly and readOnly cannot both be enabled")
+	}
+
+	if cfg.EntriesPath != "" && cfg.PurgeAuthToken == "" {
+		return errors.New("purgeAuthToken must be set to list entries")
+	}
+
+	// Only the configured paths are known not to mutate on POST.
+	if cfg.CachePostBody && len(cfg.PostBodyPaths) == 0 {
+		return errors.New("postBodyPaths must be set to cache POST requests")
+	}
+
+	if cfg.ExpiryJitter < 0 {
+		return errors.New("expiryJitter must be greater or equal to 0")
+	}
+
+	if cfg.NegativeTTL < 0 {
+		return errors.New("negativeTtl must be greater or equal to 0")
+	}
+
+	for _, code := range cfg.CacheableStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid cacheable status code %d", code)
+		}
+	}
+
+	for _, name := range cfg.BodyTransforms {
+		if _, ok := bodyTransforms[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("unknown body transform %q", name)
+		}
+	}
+
+	return nil
+}
+
+// cacheMethods returns the set of upper-cased cacheable methods, falling back to
+// GET and HEAD when none are configured.
+func cacheMethods(methods []string) map[string]struct{} {
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	set := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		set[strings.ToUpper(method)] = struct{}{}
+	}
+
+	return set
+}
+
 type cacheData struct {
 	Status  int
 	Headers http.Header
-	Body    []byte
+	Body    []byte `json:"-"`
+	Expires time.Time
+	Vary    []string `json:",omitempty"`
+
+	// Variants lists the keys of the variants of a vary manifest with when
+	// they were stored, when their number is bounded.
+	Variants map[string]time.Time `json:",omitempty"`
+
+	// Stored is when the response was stored, to compute its Age.
+	Stored time.Time `json:",omitempty"`
+
+	// Immutable entries are never revalidated while fresh, see RFC 8246.
+	Immutable bool `json:",omitempty"`
+
+	// MustRevalidate entries are never served stale.
+	MustRevalidate bool `json:",omitempty"`
+
+	// Key is the cache key of the entry, which backends may only store hashed.
+	Key string `json:",omitempty"`
+
+	// body streams the body of an entry read from a streaming backend, in
+	// place of Body.
+	body io.ReadCloser
 }
 
 // ServeHTTP serves an HTTP request.
 func (m *cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	os.Stdout.WriteString("ПАЛУНДРА, ПРИШЕЛ ЗАПРОС!!\n")
+	if m.cfg.MetricsPath != "" && r.URL.Path == m.cfg.MetricsPath {
+		m.serveMetrics(w)
+		return
+	}
 
-	cs := cacheMissStatus
-	key := cacheKey(r)
+	if m.cfg.HealthPath != "" && r.URL.Path == m.cfg.HealthPath {
+		m.serveHealth(w)
+		return
+	}
 
-	b, err := m.cache.Get(key)
-	if err == nil {
-		var data cacheData
+	if m.cfg.EntriesPath != "" && r.URL.Path == m.cfg.EntriesPath && r.Method == http.MethodGet {
+		m.serveEntries(w, r)
+		return
+	}
+
+	if r.Method == methodPurge && m.cfg.PurgeAuthToken != "" {
+		m.purge(w, r)
+		return
+	}
+
+	// The next handler takes over the connection of an upgrade, so it gets
+	// the writer unwrapped.
+	if upgradeRequest(r) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	bodyKeyed := m.bodyKeyed(r)
+	if _, ok := m.methods[r.Method]; !ok && !bodyKeyed {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	if m.bypassed(r.URL.Path) {
+		m.logger.Debug("Path bypasses the cache", "path", r.URL.Path)
+		m.setCacheStatus(w.Header(), cacheStatus{fwd: fwdBypass})
+		m.next.ServeHTTP(w, r)
+		return
+	}
 
-		err := json.Unmarshal(b, &data)
+	if bodyKeyed {
+		ok, err := bufferBody(r)
 		if err != nil {
-			cs = cacheErrorStatus
-			log.Printf("Error unmarshaling cache data: %v", err)
-		} else {
-			for key, vals := range data.Headers {
-				for _, val := range vals {
-					w.Header().Add(key, val)
-				}
-			}
-			if m.cfg.AddStatusHeader {
-				w.Header().Set(cacheHeader, cacheHitStatus)
-			}
-			w.WriteHeader(data.Status)
-			_, _ = w.Write(data.Body)
+			m.logger.Debug("Error reading request body", "path", r.URL.Path, "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !ok {
+			m.logger.Debug("Request body is too large to be part of the key", "path", r.URL.Path)
+			m.setCacheStatus(w.Header(), cacheStatus{fwd: fwdBypass})
+			m.next.ServeHTTP(w, r)
 			return
 		}
 	}
 
-	if m.cfg.AddStatusHeader {
-		w.Header().Set(cacheHeader, cs)
+	if r.Method == http.MethodHead {
+		w = headWriter{ResponseWriter: w}
 	}
 
-	rw := &responseWriter{ResponseWriter: w}
-	m.next.ServeHTTP(rw, r)
+	cs := cacheStatus{fwd: fwdMiss}
+	key := m.cacheKey(r)
+
+	noStore, noCache := requestCacheControl(r)
+	switch {
+	case noStore:
+		m.logger.Debug("Request bypasses the cache", "key", key)
+		m.setCacheStatus(w.Header(), cacheStatus{fwd: fwdRequest})
+		m.next.ServeHTTP(w, r)
+		return
+	case noCache:
+		if m.serveImmutable(w, r, key) {
+			return
+		}
+		m.logger.Debug("Request skips the stored response", "key", key)
+		m.fetch(w, r, key, cacheStatus{fwd: fwdRequest})
+		return
+	}
+
+	if m.cfg.ShadowMode {
+		m.shadow(w, r, key)
+		return
+	}
+
+	// The write only mode fills the cache without serving from it.
+	if m.cfg.WriteOnly {
+		atomic.AddUint64(&m.stats.misses, 1)
+		m.logger.Debug("Write only mode skips the stored response", "key", key)
+		m.fetch(w, r, key, cacheStatus{fwd: fwdBypass, detail: detailWriteOnly})
+		return
+	}
+
+	data, err := m.lookup(key, r)
+	defer data.close()
+
+	if err == nil && m.fresh(data) {
+		m.serve(w, r, key, data, m.hitStatus(data))
+		return
+	}
+
+	// The background refresh outlives the request, so the body is read first.
+	if err == nil && m.servableStale(data) {
+		if err = data.load(); err == nil {
+			m.serve(w, r, key, data, m.hitStatus(data))
+			m.refreshInBackground(r, key, data)
+			return
+		}
+	}
+
+	if err != nil && !errors.Is(err, errCacheMiss) {
+		cs.detail = cacheErrorStatus
+		atomic.AddUint64(&m.stats.errors, 1)
+		m.logger.Error("Error reading cache item", "key", key, "error", err)
+
+		if m.cfg.OnCacheError == onCacheErrorFail {
+			m.setCacheStatus(w.Header(), cs)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	expiry, ok := m.cacheable(r, w, rw.status)
+	m.logger.Debug("Cache lookup", "key", key, "result", cs.legacy())
+
+	// Concurrent misses wait for a single request to reach the origin, then
+	// retry the cache and only go to the origin themselves if nothing was stored.
+	fetched := m.flight.Do(key, func() {
+		switch {
+		case err == nil && m.revalidatable(data):
+			m.revalidate(w, r, key, data)
+		case err == nil && m.servableOnError(data):
+			m.fetchOrStale(w, r, key, data, cs)
+		default:
+			m.fetch(w, r, key, cs)
+		}
+	})
+	if fetched {
+		return
+	}
+
+	cached, err := m.lookup(key, r)
+	defer cached.close()
+
+	if err == nil && m.fresh(cached) {
+		m.serve(w, r, key, cached, m.hitStatus(cached))
+		return
+	}
+
+	m.fetch(w, r, key, cs)
+}
+
+// requestCacheControl reports whether the request forbids storing the response
+// or using a stored one. Pragma is only honored without Cache-Control.
+func requestCacheControl(r *http.Request) (noStore, noCache bool) {
+	cc := r.Header.Get("Cache-Control")
+	if cc == "" {
+		return false, strings.EqualFold(strings.TrimSpace(r.Header.Get("Pragma")), "no-cache")
+	}
+
+	dir, err := cacheobject.ParseRequestCacheControl(cc)
+	if err != nil {
+		return false, false
+	}
+
+	return dir.NoStore, dir.NoCache
+}
+
+// fetch forwards the request to the next handler and stores the response.
+func (m *cache) fetch(w http.ResponseWriter, r *http.Request, key string, cs cacheStatus) {
+	if cs.detail == "" {
+		atomic.AddUint64(&m.stats.misses, 1)
+	}
+
+	// HEAD responses are never stored, so there is no body to buffer.
+	if r.Method == http.MethodHead {
+		m.setCacheStatus(w.Header(), cs)
+		m.forward(w, r)
+		return
+	}
+
+	// The headers are stored as the next handler sent them, without those set
+	// on w by the plugin or the middlewares in front of it.
+	var header http.Header
+
+	rw := &responseWriter{ResponseWriter: w, body: getBuffer(), maxBody: m.cfg.MaxBodyBytes, header: make(http.Header)}
+	defer putBuffer(rw.body)
+
+	rw.onWriteHeader = func(status int) {
+		header = rw.header.Clone()
+		_, cs.stored = m.cacheable(r, header, status)
+		cs.stored = cs.stored && !rw.streaming
+		m.dropSurrogateControl(w.Header())
+		m.setCacheStatus(w.Header(), cs)
+	}
+
+	// A panic of the next handler, such as the http.ErrAbortHandler of an
+	// aborted proxied response, propagates before the partial body is stored.
+	m.forward(rw, r)
+
+	// A next handler returning without writing sends an empty 200, with the
+	// headers it set.
+	if rw.status == 0 && !rw.hijacked {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.overflow {
+		m.logger.Debug("Response exceeds the maximum body size", "key", key, "maxBodyBytes", m.cfg.MaxBodyBytes)
+		return
+	}
+	if rw.streaming {
+		m.logger.Debug("Response is streamed", "key", key)
+		return
+	}
+	if rw.hijacked {
+		m.logger.Debug("Connection was hijacked", "key", key)
+		return
+	}
+
+	m.store(key, r, rw.status, header, rw.body.Bytes())
+}
+
+// lookup returns the entry stored for the request. When the stored entry is a
+// vary manifest, the variant matching the request headers is returned instead.
+// The body of the returned entry may be streamed, it must be closed once served.
+func (m *cache) lookup(key string, r *http.Request) (*cacheData, error) {
+	data, err := m.open(key)
+	if err != nil || len(data.Vary) == 0 {
+		return data, err
+	}
+	data.close()
+
+	return m.open(varyKey(key, data.Vary, r))
+}
+
+// open returns the entry stored for key, streaming its body when the backend
+// supports it.
+func (m *cache) open(key string) (*cacheData, error) {
+	s, ok := m.cache.(streamer)
 	if !ok {
+		return m.get(key)
+	}
+
+	rc, err := s.open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readEntry(rc)
+	if err != nil {
+		_ = rc.Close()
+		return nil, m.dropCorrupted(key, err)
+	}
+
+	return data, nil
+}
+
+// get returns the entry stored for key with its body in memory.
+func (m *cache) get(key string) (*cacheData, error) {
+	b, err := m.cache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := unmarshalEntry(b)
+	if err != nil {
+		return nil, m.dropCorrupted(key, err)
+	}
+
+	return data, nil
+}
+
+// dropCorrupted removes the entry stored for key that could not be unmarshaled,
+// so that the next request stores it again instead of failing the same way. It
+// returns the unmarshaling error err.
+func (m *cache) dropCorrupted(key string, err error) error {
+	if derr := m.cache.Delete(key); derr != nil && !errors.Is(derr, errCacheMiss) {
+		m.logger.Warn("Error removing corrupted cache item", "key", key, "error", derr)
+	}
+
+	return fmt.Errorf("error unmarshaling cache data: %w", err)
+}
+
+// fresh reports whether data may be served without contacting the origin.
+// Entries stored without an expiry are fresh until they are removed.
+func (m *cache) fresh(data *cacheData) bool {
+	return data.Expires.IsZero() || m.clock.Now().Before(data.Expires)
+}
+
+// age returns the Age of the stored response data in seconds, the time it has
+// been stored added to the Age it was received with, see RFC 9111 section 4.2.3.
+// Entries stored without a store time have no known age.
+func (m *cache) age(data *cacheData) (int, bool) {
+	if data.Stored.IsZero() {
+		return 0, false
+	}
+
+	age := int(m.clock.Now().Sub(data.Stored) / time.Second)
+	if age < 0 {
+		age = 0
+	}
+
+	if upstream, err := strconv.Atoi(strings.TrimSpace(data.Headers.Get("Age"))); err == nil && upstream > 0 {
+		age += upstream
+	}
+
+	return age, true
+}
+
+// serve sends the stored response data for key.
+func (m *cache) serve(w http.ResponseWriter, r *http.Request, key string, data *cacheData, cs cacheStatus) {
+	atomic.AddUint64(&m.stats.hits, 1)
+
+	compressible := m.compressible(data)
+	if compressible && r.Header.Get("Range") == "" && !notModified(r, data.Headers) {
+		data = m.encoded(r, key, data)
+	}
+
+	for key, vals := range data.Headers {
+		for _, val := range vals {
+			w.Header().Add(key, val)
+		}
+	}
+	if compressible {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+	if age, ok := m.age(data); ok {
+		w.Header().Set("Age", strconv.Itoa(age))
+	}
+	m.setCacheStatus(w.Header(), cs)
+
+	if cs.hit && m.cfg.AdaptiveTTL {
+		m.adaptive.hit(key)
+	}
+
+	attrs := []interface{}{"key", key, "status", data.Status, "cacheStatus", cs.format(m.name)}
+	if cs.ttl != nil {
+		attrs = append(attrs, "ttl", *cs.ttl)
+	}
+
+	if data.Status == http.StatusOK && notModified(r, data.Headers) {
+		writeNotModified(w)
+		m.logger.Debug("Served not modified response", attrs...)
 		return
 	}
 
-	data := cacheData{
-		Status:  rw.status,
-		Headers: w.Header().Clone(),
-		Body:    rw.body,
+	if data.Status == http.StatusOK && r.Header.Get("Range") != "" {
+		m.logger.Debug("Serving cached range", append(attrs, "range", r.Header.Get("Range"))...)
+		m.serveRange(w, r, key, data)
+		return
 	}
 
-	data.Headers.Del("Date")
-	data.Headers.Del("Set-Cookie")
-	data.Headers.Del("Cache-Status")
+	w.WriteHeader(data.Status)
 
-	b, err = json.Marshal(data)
+	if data.body == nil {
+		n, _ := w.Write(data.Body)
+		m.logger.Debug("Served cached response", append(attrs, "bytes", n)...)
+		return
+	}
+
+	n, err := io.Copy(w, data.body)
 	if err != nil {
-		log.Printf("Error serializing cache item: %v", err)
+		m.logger.Error("Error streaming cache item", "key", key, "error", err)
+		return
 	}
 
-	if err = m.cache.Set(key, b, expiry); err != nil {
-		log.Printf("Error setting cache item: %v", err)
+	m.logger.Debug("Served cached response", append(attrs, "bytes", n)...)
+}
+
+func (m *cache) store(key string, r *http.Request, status int, h http.Header, body []byte) {
+	// The body of a request cancelled by its client may be incomplete.
+	if err := r.Context().Err(); err != nil {
+		m.logger.Debug("Request was cancelled, not storing the response", "key", key, "error", err)
+		return
 	}
+
+	// A 304 has no body of its own, it refreshes the stored response.
+	if status == http.StatusNotModified {
+		m.storeNotModified(key, r, h)
+		return
+	}
+
+	expiry, ok := m.cacheable(r, h, status)
+	if !ok {
+		m.logger.Debug("Response is not cacheable", "key", key, "status", status)
+		return
+	}
+
+	// A response to an authorized request that may not be shared varies on its
+	// credentials.
+	vh := h
+	if m.perCredentials(r, h) {
+		vh = h.Clone()
+		addVary(vh, "Authorization")
+	}
+
+	vary, ok := varyHeaders(vh)
+	if !ok {
+		m.logger.Debug("Response varies on all headers", "key", key)
+		return
+	}
+
+	if !completeBody(h, body) {
+		m.logger.Warn("Response body does not match its Content-Length", "key", key, "bytes", len(body))
+		return
+	}
+
+	// The client already got the body, only storing it is skipped.
+	if int64(len(body)) < m.cfg.MinBodyBytes {
+		m.logger.Debug("Response is smaller than the minimum body size", "key", key, "bytes", len(body))
+		return
+	}
+
+	now := m.clock.Now()
+	if m.backoff.suspended(now) {
+		m.logger.Debug("Writes are suspended after a failure", "key", key)
+		return
+	}
+
+	// The client already got the original body, only the stored one changes.
+	body, transformed := m.transform(h, body)
+
+	if m.cfg.AdaptiveTTL {
+		expiry = m.adaptive.scale(key, body, expiry, time.Duration(m.cfg.MaxExpiry)*time.Second)
+	}
+
+	data := cacheData{
+		Status:         status,
+		Headers:        m.storedHeaders(h),
+		Body:           body,
+		Expires:        now.Add(expiry),
+		Stored:         now,
+		Immutable:      immutable(h),
+		MustRevalidate: mustRevalidate(h),
+	}
+	if transformed {
+		transformedHeaders(data.Headers, len(body))
+	}
+
+	ttl := expiry + m.retention(&data)
+
+	if len(vary) > 0 {
+		vkey := varyKey(key, vary, r)
+		manifest := cacheData{Vary: vary, Variants: m.variants(key, vkey, vary, now)}
+		if err := m.set(key, manifest, ttl); err != nil {
+			m.setFailed(key, err)
+			return
+		}
+		key = vkey
+	}
+
+	if err := m.set(key, data, ttl); err != nil {
+		m.setFailed(key, err)
+		return
+	}
+
+	m.backoff.succeeded()
+	atomic.AddUint64(&m.stats.sets, 1)
+
+	m.logger.Debug("Stored response", "key", key, "status", status, "ttl", expiry, "bytes", len(body))
+}
+
+// completeBody reports whether body has the length declared by the
+// Content-Length header of h, if any, so truncated responses are not stored.
+func completeBody(h http.Header, body []byte) bool {
+	cl := h.Get("Content-Length")
+	if cl == "" {
+		return true
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(cl), 10, 64)
+
+	return err == nil && n == int64(len(body))
 }
 
-func (m *cache) cacheable(r *http.Request, w http.ResponseWriter, status int) (time.Duration, bool) {
-	if status == http.StatusOK {
-		return time.Duration(m.cfg.MaxExpiry) * time.Second, true
+// setFailed records the failure to store the response for key. Writes are
+// suspended for a while, as a failing backend such as a full disk would
+// otherwise fail again for every response. The response itself has already
+// been sent to the client.
+func (m *cache) setFailed(key string, err error) {
+	atomic.AddUint64(&m.stats.errors, 1)
+
+	d := m.backoff.failed(m.clock.Now())
+	m.logger.Error("Error setting cache item, suspending writes", "key", key, "error", err, "backoff", d)
+}
+
+// unstoredHeaders are not stored with a response unless kept by the
+// configuration. Date is sent with every response and Set-Cookie is specific to
+// a client.
+var unstoredHeaders = []string{"Date", "Set-Cookie"}
+
+// headerSet returns the set of the canonical forms of names.
+func headerSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			set[http.CanonicalHeaderKey(name)] = struct{}{}
+		}
+	}
+
+	return set
+}
+
+// hopByHopHeaders only apply to a single connection, see RFC 7230 section 6.1.
+// Proxy-Connection is not standard but still sent by some clients.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// storedHeaders returns a copy of h without the headers that must not be
+// stored, including the hop-by-hop headers listed in its Connection header, the
+// override headers, the Cache-Status header and an honored Surrogate-Control
+// header. The configuration can keep the unstored headers and strip others.
+func (m *cache) storedHeaders(h http.Header) http.Header {
+	stored := h.Clone()
+	if stored == nil {
+		stored = make(http.Header)
+	}
+
+	for _, v := range stored.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				stored.Del(name)
+			}
+		}
+	}
+
+	for _, name := range unstoredHeaders {
+		if _, ok := m.kept[name]; !ok {
+			stored.Del(name)
+		}
+	}
+	for _, name := range m.cfg.StripHeaders {
+		stored.Del(strings.TrimSpace(name))
+	}
+	for _, name := range hopByHopHeaders {
+		stored.Del(name)
+	}
+	for _, name := range []string{cacheHeader, m.cfg.TTLOverrideHeader, m.cfg.NoCacheHeader, m.cfg.StatusHeaderName} {
+		if name != "" {
+			stored.Del(name)
+		}
+	}
+	m.dropSurrogateControl(stored)
+
+	return stored
+}
+
+func (m *cache) set(key string, data cacheData, expiry time.Duration) error {
+	data.Key = key
+
+	b, err := marshalEntry(&data)
+	if err != nil {
+		return fmt.Errorf("error serializing cache item: %w", err)
 	}
 
-	reasons, expireBy, err := cachecontrol.CachableResponseWriter(r, status, w, cachecontrol.Options{})
+	return m.cache.Set(key, b, expiry)
+}
+
+func (m *cache) cacheable(r *http.Request, h http.Header, status int) (time.Duration, bool) {
+	maxExpiry := time.Duration(m.cfg.MaxExpiry) * time.Second
+
+	// Error responses are kept for the negative TTL at most, even without
+	// explicit freshness information.
+	negative := m.cfg.NegativeTTL > 0 && status >= http.StatusBadRequest
+	if negative {
+		maxExpiry = time.Duration(m.cfg.NegativeTTL) * time.Second
+	}
+
+	// Nothing is stored in read only mode, stored responses are still served.
+	if m.cfg.ReadOnly {
+		return 0, false
+	}
+
+	// A HEAD response has no body and would shadow the GET entry it shares.
+	if r.Method == http.MethodHead {
+		return 0, false
+	}
+
+	retry, retryOK := m.retryAfterTTL(h, status)
+	statusTTL, statusOK := m.statusTTL(h, status)
+	if _, ok := m.codes[status]; !ok && !retryOK && !statusOK {
+		return 0, false
+	}
+
+	// A response setting a cookie is usually specific to its client.
+	if !m.cfg.CacheWithSetCookie && h.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+
+	if !m.cacheableType(h) || streamed(h) {
+		return 0, false
+	}
+
+	// The override headers of the origin take precedence over Cache-Control.
+	if m.cfg.NoCacheHeader != "" && h.Get(m.cfg.NoCacheHeader) != "" {
+		return 0, false
+	}
+
+	ttl, ok := m.ttlOverride(h)
+	if !ok && m.cfg.HonorSurrogateControl {
+		ttl, ok = surrogateControl(h)
+	}
+	if ok {
+		if ttl <= 0 {
+			return 0, false
+		}
+		if maxExpiry < ttl {
+			ttl = maxExpiry
+		}
+		return m.jitter(ttl), true
+	}
+
+	// The Retry-After delay is not jittered, the origin asked for it.
+	if retryOK {
+		return retry, true
+	}
+
+	if statusOK {
+		if statusTTL <= 0 {
+			return 0, false
+		}
+		return m.jitter(statusTTL), true
+	}
+
+	if m.cfg.ForceCache && status == http.StatusOK {
+		return m.jitter(maxExpiry), true
+	}
+
+	reasons, expireBy, _, obj, err := cacheobject.UsingRequestResponseWithObject(r, status, h, false)
+	if err == nil && m.privateAuthorized(r, h) {
+		reasons = withoutPrivateReasons(reasons)
+	}
 	if err != nil || len(reasons) > 0 {
 		return 0, false
 	}
 
-	expiry := time.Until(expireBy)
-	maxExpiry := time.Duration(m.cfg.MaxExpiry) * time.Second
+	// Without explicit freshness information a 200 is kept for the maximum expiry.
+	if expireBy.IsZero() && (status == http.StatusOK || negative) {
+		return m.jitter(maxExpiry), true
+	}
+
+	expiry := expireBy.Sub(obj.NowUTC)
+	if expiry <= 0 {
+		return 0, false
+	}
 
 	if maxExpiry < expiry {
 		expiry = maxExpiry
 	}
 
-	return expiry, true
+	return m.jitter(expiry), true
+}
+
+// statusTTL returns the TTL configured for responses with status, which makes
+// them cacheable and replaces their freshness lifetime. It is zero for
+// responses that Cache-Control forbids to store in a shared cache.
+func (m *cache) statusTTL(h http.Header, status int) (time.Duration, bool) {
+	secs, ok := m.cfg.StatusTTLOverrides[status]
+	if !ok {
+		return 0, false
+	}
+
+	dir, err := cacheobject.ParseResponseCacheControl(h.Get("Cache-Control"))
+	if err == nil && (dir.NoStore || dir.PrivatePresent) {
+		return 0, true
+	}
+
+	return time.Duration(secs) * time.Second, true
+}
+
+// ttlOverride returns the TTL set by the TTL override header of h, in seconds.
+// Values that are not integers are ignored.
+func (m *cache) ttlOverride(h http.Header) (time.Duration, bool) {
+	if m.cfg.TTLOverrideHeader == "" {
+		return 0, false
+	}
+
+	secs, err := strconv.Atoi(strings.TrimSpace(h.Get(m.cfg.TTLOverrideHeader)))
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(secs) * time.Second, true
 }
 
-func cacheKey(r *http.Request) string {
-	return r.Method + r.Host + r.URL.Path + "?" + r.URL.RawQuery +
-		"|Authorization:" + r.Header.Get("Authorization")
+// jitter shortens expiry by a random duration of up to the configured jitter,
+// so entries stored together do not expire together. Entries are never made to
+// outlive their freshness, and keep at least half of it.
+func (m *cache) jitter(expiry time.Duration) time.Duration {
+	band := time.Duration(m.cfg.ExpiryJitter) * time.Second
+	if band > expiry/2 {
+		band = expiry / 2
+	}
+	if band <= 0 {
+		return expiry
+	}
+
+	m.rngMu.Lock()
+	defer m.rngMu.Unlock()
+
+	return expiry - time.Duration(m.rng.Int63n(int64(band)+1))
 }
 
+// maxPooledBuffer bounds the capacity of the buffers returned to bufferPool,
+// so a single large response does not pin its memory.
+const maxPooledBuffer = 1 << 20
+
+// bufferPool holds the buffers accumulating the bodies of fetched responses.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns buf to the pool. Its bytes must not be referenced anymore,
+// which holds once stored as entries are encoded into a new slice.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// errHijackUnsupported is returned by Hijack when the underlying writer cannot
+// be hijacked.
+var errHijackUnsupported = errors.New("connection does not support hijacking")
+
 type responseWriter struct {
 	http.ResponseWriter
 	status int
-	body   []byte
+	body   *bytes.Buffer
+
+	// maxBody is the number of bytes buffered before the response is
+	// considered too large to be cached. Zero means unlimited.
+	maxBody  int64
+	overflow bool
+
+	// streaming is set for streamed responses, detected by their headers or
+	// by a flush of the next handler. Their body is not buffered.
+	streaming bool
+
+	// hijacked is set once the next handler took over the connection, so the
+	// response is not known.
+	hijacked bool
+
+	// header holds the headers set by the next handler until they are sent,
+	// apart from those already set on the underlying writer. Without it, the
+	// next handler sets the headers of the underlying writer directly.
+	header http.Header
+
+	// onWriteHeader is called with the status before the headers are sent.
+	onWriteHeader func(status int)
 }
 
 func (rw *responseWriter) Header() http.Header {
-	return rw.ResponseWriter.Header()
+	if rw.header == nil || rw.status != 0 {
+		return rw.ResponseWriter.Header()
+	}
+	return rw.header
 }
 
 func (rw *responseWriter) Write(p []byte) (int, error) {
-	rw.body = append(rw.body, p...)
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	switch {
+	case rw.overflow, rw.streaming:
+	case rw.maxBody > 0 && int64(rw.body.Len()+len(p)) > rw.maxBody:
+		rw.overflow = true
+		rw.body.Reset()
+	default:
+		_, _ = rw.body.Write(p)
+	}
+
 	return rw.ResponseWriter.Write(p)
 }
 
 func (rw *responseWriter) WriteHeader(s int) {
-	rw.status = s
+	if rw.status == 0 {
+		rw.streaming = rw.streaming || streamed(rw.Header())
+		if rw.header != nil {
+			h := rw.ResponseWriter.Header()
+			for name, vals := range rw.header {
+				h[name] = vals
+			}
+		}
+		rw.status = s
+		if rw.onWriteHeader != nil {
+			rw.onWriteHeader(s)
+		}
+	}
 	rw.ResponseWriter.WriteHeader(s)
-}
\ No newline at end of file
+}
+
+// Flush sends the buffered data of the underlying writer to the client. A
+// flushed response is being streamed and is never stored.
+func (rw *responseWriter) Flush() {
+	rw.streaming = true
+	rw.body.Reset()
+
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the next handler take over the connection when the underlying
+// writer supports it, for example to upgrade it to a WebSocket.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackUnsupported
+	}
+
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+
+	return conn, brw, err
+}
+
+// ReadFrom copies src to the response. Bodies that are not buffered are
+// handed to the underlying writer, which may use sendfile.
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok && (rw.overflow || rw.streaming) {
+		return rf.ReadFrom(src)
+	}
+
+	// The writer only exposes Write, so io.Copy does not call ReadFrom again.
+	return io.Copy(struct{ io.Writer }{rw}, src)
+}
+
+// headWriter discards the body of a response to a HEAD request.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -1,12 +1,21 @@
 package plugin_simplecache
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -35,6 +44,121 @@ func TestNew(t *testing.T) {
 			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: -1},
 			wantErr: false,
 		},
+		{
+			name:    "should error on an unknown backend",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, Backend: "foo"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if path is empty",
+			cfg:     &Config{MaxExpiry: 300, Cleanup: 600},
+			wantErr: true,
+		},
+		{
+			name:    "should error if path is the root directory",
+			cfg:     &Config{Path: "/", MaxExpiry: 300, Cleanup: 600},
+			wantErr: true,
+		},
+		{
+			name:    "should error if path is a file",
+			cfg:     &Config{Path: createTempFile(t), MaxExpiry: 300, Cleanup: 600},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an invalid dirMode",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, DirMode: "rwx"},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an invalid fileMode",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, FileMode: "01777"},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an invalid cacheable status code",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, CacheableStatusCodes: []int{200, 1000}},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an invalid key template",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, KeyTemplate: "{{.Path"},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an invalid log level",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, LogLevel: "verbose"},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an invalid noCachePaths pattern",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, NoCachePaths: []string{"^/admin/(", "^/login"}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if POST requests are cached without paths",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, CachePostBody: true},
+			wantErr: true,
+		},
+		{
+			name:    "should error on a negative maxEntries",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, MaxEntries: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if minBodyBytes exceeds maxBodyBytes",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, MinBodyBytes: 100, MaxBodyBytes: 10},
+			wantErr: true,
+		},
+		{
+			name:    "should error if both writeOnly and readOnly are enabled",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, WriteOnly: true, ReadOnly: true},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an invalid status TTL override",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, StatusTTLOverrides: map[int]int{999: 10}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if entries are listed without a token",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, EntriesPath: "/_cache/entries"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if the origin timeout is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, OriginTimeout: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an unknown cache error behavior",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, OnCacheError: "retry"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if the compression threshold is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, CompressMinBytes: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if the maximum key length is too small",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, MaxKeyLength: 64},
+			wantErr: true,
+		},
+		{
+			name:    "should error on an unknown body transform",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, BodyTransforms: []string{"minifyXml"}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if the redis backend has no address",
+			cfg:     &Config{MaxExpiry: 300, Cleanup: 600, Backend: "redis"},
+			wantErr: true,
+		},
+		{
+			name:    "should not require a path for the memory backend",
+			cfg:     &Config{MaxExpiry: 300, Cleanup: 600, Backend: "memory"},
+			wantErr: false,
+		},
 		{
 			name:    "should be valid",
 			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600},
@@ -64,7 +188,7 @@ func TestCache_ServeHTTP(t *testing.T) {
 		rw.WriteHeader(http.StatusOK)
 	}
 
-	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true}
 
 	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
 	if err != nil {
@@ -89,19 +213,1793 @@ func TestCache_ServeHTTP(t *testing.T) {
 	}
 }
 
-func createTempDir(tb testing.TB) string {
-	tb.Helper()
+func TestCache_ServeHTTPNestedPath(t *testing.T) {
+	dir := createTempDir(t)
 
-	dir, err := ioutil.TempDir("./", "example")
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(req.URL.Path))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
 	if err != nil {
-		tb.Fatal(err)
+		t.Fatal(err)
 	}
 
-	tb.Cleanup(func() {
-		if err = os.RemoveAll(dir); err != nil {
-			tb.Fatal(err)
+	for _, path := range []string{"/a", "/a/b", "/a/b/c"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost"+path+"?x=1|2", nil)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if body := rw.Body.String(); body != path {
+			t.Errorf("unexpected body for %q: got %q", path, body)
 		}
-	})
+	}
 
-	return dir
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/a/b/c?x=1|2", nil)
+	rw := httptest.NewRecorder()
+
+	c.ServeHTTP(rw, req)
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want \"hit\", got: %q", state)
+	}
+}
+
+func TestCache_ServeHTTPLogging(t *testing.T) {
+	tests := []struct {
+		name    string
+		debug   bool
+		wantLog bool
+	}{
+		{
+			name:    "should be silent with debug disabled",
+			debug:   false,
+			wantLog: false,
+		},
+		{
+			name:    "should log with debug enabled",
+			debug:   true,
+			wantLog: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			dir := createTempDir(t)
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true, Debug: test.debug}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+			c.ServeHTTP(httptest.NewRecorder(), req)
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := buf.Len() > 0; got != test.wantLog {
+				t.Errorf("unexpected log output: want output %t, got %q", test.wantLog, buf.String())
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPMethods(t *testing.T) {
+	tests := []struct {
+		name       string
+		methods    []string
+		method     string
+		wantCalls  int
+		wantStatus string
+	}{
+		{
+			name:       "should cache GET by default",
+			method:     http.MethodGet,
+			wantCalls:  1,
+			wantStatus: "hit",
+		},
+		{
+			name:       "should bypass POST by default",
+			method:     http.MethodPost,
+			wantCalls:  2,
+			wantStatus: "",
+		},
+		{
+			name:       "should cache configured methods regardless of case",
+			methods:    []string{"post"},
+			method:     http.MethodPost,
+			wantCalls:  1,
+			wantStatus: "hit",
+		},
+		{
+			name:       "should bypass methods missing from the configured list",
+			methods:    []string{"post"},
+			method:     http.MethodGet,
+			wantCalls:  2,
+			wantStatus: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true, CacheMethods: test.methods}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rw *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(test.method, "http://localhost/some/path", nil))
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if state := rw.Header().Get("Cache-Status"); state != test.wantStatus {
+				t.Errorf("unexpected cache state: want %q, got: %q", test.wantStatus, state)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		forceCache   bool
+		wantCalls    int
+	}{
+		{
+			name:      "should cache a 200 without cache control",
+			wantCalls: 1,
+		},
+		{
+			name:         "should not cache a 200 with no-store",
+			cacheControl: "no-store",
+			wantCalls:    2,
+		},
+		{
+			name:         "should not cache a 200 with private",
+			cacheControl: "private",
+			wantCalls:    2,
+		},
+		{
+			name:         "should not cache a 200 with max-age=0",
+			cacheControl: "max-age=0",
+			wantCalls:    2,
+		},
+		{
+			name:         "should cache a 200 with no-store when forced",
+			cacheControl: "no-store",
+			forceCache:   true,
+			wantCalls:    1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if test.cacheControl != "" {
+					rw.Header().Set("Cache-Control", test.cacheControl)
+				}
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, ForceCache: test.forceCache}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPMaxBodyBytes(t *testing.T) {
+	dir := createTempDir(t)
+
+	body := bytes.Repeat([]byte("a"), 64)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(body[:32])
+		_, _ = rw.Write(body[32:])
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, MaxBodyBytes: 48}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	if !bytes.Equal(rw.Body.Bytes(), body) {
+		t.Errorf("unexpected body: want %q, got %q", body, rw.Body.Bytes())
+	}
+
+	if n := countEntries(t, dir); n != 0 {
+		t.Errorf("unexpected cache files: want 0, got %d", n)
+	}
+}
+
+func TestCache_ServeHTTPBodySizeWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		wantEntries int
+	}{
+		{
+			name:        "should not store a body below the minimum",
+			size:        8,
+			wantEntries: 0,
+		},
+		{
+			name:        "should store a body within the window",
+			size:        32,
+			wantEntries: 1,
+		},
+		{
+			name:        "should not store a body above the maximum",
+			size:        64,
+			wantEntries: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			body := bytes.Repeat([]byte("a"), test.size)
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=20")
+				_, _ = rw.Write(body)
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, MinBodyBytes: 16, MaxBodyBytes: 48}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if !bytes.Equal(rw.Body.Bytes(), body) {
+				t.Errorf("unexpected body: want %q, got %q", body, rw.Body.Bytes())
+			}
+			if n := countEntries(t, dir); n != test.wantEntries {
+				t.Errorf("unexpected cache files: want %d, got %d", test.wantEntries, n)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPContentEncoding(t *testing.T) {
+	dir := createTempDir(t)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("some compressed body"))
+	_ = zw.Close()
+	body := buf.Bytes()
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Content-Encoding", "gzip")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(body)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true, CompressOnDisk: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want \"hit\", got: %q", state)
+	}
+	if encoding := rw.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Errorf("unexpected content encoding: want \"gzip\", got: %q", encoding)
+	}
+	if !bytes.Equal(rw.Body.Bytes(), body) {
+		t.Errorf("unexpected body: want %x, got %x", body, rw.Body.Bytes())
+	}
+}
+
+func TestCache_ServeHTTPSetCookie(t *testing.T) {
+	tests := []struct {
+		name               string
+		cacheWithSetCookie bool
+		wantCalls          int
+	}{
+		{
+			name:      "should not cache responses setting a cookie",
+			wantCalls: 2,
+		},
+		{
+			name:               "should cache responses setting a cookie when enabled",
+			cacheWithSetCookie: true,
+			wantCalls:          1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.Header().Set("Set-Cookie", "session=abc")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, CacheWithSetCookie: test.cacheWithSetCookie}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if cookie := rw.Header().Get("Set-Cookie"); cookie != "session=abc" {
+				t.Errorf("unexpected Set-Cookie: want %q, got %q", "session=abc", cookie)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPStatusCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		codes      []int
+		status     int
+		wantCalls  int
+		wantStatus string
+	}{
+		{
+			name:       "should cache a 404 by default",
+			status:     http.StatusNotFound,
+			wantCalls:  1,
+			wantStatus: "hit",
+		},
+		{
+			name:       "should never store a 500 by default",
+			status:     http.StatusInternalServerError,
+			wantCalls:  2,
+			wantStatus: "miss",
+		},
+		{
+			name:       "should not store a status missing from the configured list",
+			codes:      []int{http.StatusOK},
+			status:     http.StatusNotFound,
+			wantCalls:  2,
+			wantStatus: "miss",
+		},
+		{
+			name:       "should cache a configured status",
+			codes:      []int{http.StatusInternalServerError},
+			status:     http.StatusInternalServerError,
+			wantCalls:  1,
+			wantStatus: "hit",
+		},
+		{
+			name:       "should replay a non-standard status",
+			codes:      []int{299},
+			status:     299,
+			wantCalls:  1,
+			wantStatus: "hit",
+		},
+		{
+			name:       "should replay a non-standard error status",
+			codes:      []int{520},
+			status:     520,
+			wantCalls:  1,
+			wantStatus: "hit",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=60")
+				rw.WriteHeader(test.status)
+			}
+
+			cfg := &Config{
+				Path:                 dir,
+				MaxExpiry:            10,
+				Cleanup:              20,
+				AddStatusHeader:      true,
+				LegacyStatusHeader:   true,
+				CacheableStatusCodes: test.codes,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+			var rw *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if rw.Code != test.status {
+				t.Errorf("unexpected status code: want %d, got %d", test.status, rw.Code)
+			}
+			if state := rw.Header().Get("Cache-Status"); state != test.wantStatus {
+				t.Errorf("unexpected cache state: want %q, got: %q", test.wantStatus, state)
+			}
+
+			if data, err := c.get(c.cacheKey(req)); err == nil && time.Until(data.Expires) > 10*time.Second {
+				t.Errorf("expected expiry to be capped by maxExpiry, got %s", time.Until(data.Expires))
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPNegativeTTL(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		cacheControl string
+		wantTTL      time.Duration
+	}{
+		{
+			name:         "should keep a 200 for maxExpiry",
+			status:       http.StatusOK,
+			cacheControl: "max-age=60",
+			wantTTL:      10 * time.Second,
+		},
+		{
+			name:         "should keep a 404 for the negative TTL",
+			status:       http.StatusNotFound,
+			cacheControl: "max-age=60",
+			wantTTL:      3 * time.Second,
+		},
+		{
+			name:    "should keep a 404 without freshness information for the negative TTL",
+			status:  http.StatusNotFound,
+			wantTTL: 3 * time.Second,
+		},
+		{
+			name:         "should honor a shorter lifetime of a 404",
+			status:       http.StatusNotFound,
+			cacheControl: "max-age=1",
+			wantTTL:      time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if test.cacheControl != "" {
+					rw.Header().Set("Cache-Control", test.cacheControl)
+				}
+				rw.WriteHeader(test.status)
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, NegativeTTL: 3}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			clk := newFakeClock()
+			setClock(c, clk)
+
+			serve := func() {
+				c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			serve()
+
+			clk.Advance(test.wantTTL - time.Nanosecond)
+			serve()
+
+			if calls != 1 {
+				t.Errorf("expected entry to be fresh before %s, got %d next handler calls", test.wantTTL, calls)
+			}
+
+			clk.Advance(time.Nanosecond)
+			serve()
+
+			if calls != 2 {
+				t.Errorf("expected entry to expire after %s, got %d next handler calls", test.wantTTL, calls)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPStatusTTLOverrides(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		cacheControl string
+		wantTTL      time.Duration
+	}{
+		{
+			name:    "should keep a 500 for its override",
+			status:  http.StatusInternalServerError,
+			wantTTL: 2 * time.Second,
+		},
+		{
+			name:         "should keep a 404 for its override over its lifetime",
+			status:       http.StatusNotFound,
+			cacheControl: "max-age=5",
+			wantTTL:      60 * time.Second,
+		},
+		{
+			name:         "should keep other statuses for their lifetime",
+			status:       http.StatusOK,
+			cacheControl: "max-age=5",
+			wantTTL:      5 * time.Second,
+		},
+		{
+			name:         "should not store an overridden status forbidden by Cache-Control",
+			status:       http.StatusNotFound,
+			cacheControl: "private",
+		},
+		{
+			name:   "should not store a status overridden with zero",
+			status: http.StatusGone,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if test.cacheControl != "" {
+					rw.Header().Set("Cache-Control", test.cacheControl)
+				}
+				rw.WriteHeader(test.status)
+			}
+
+			cfg := &Config{
+				MaxExpiry: 10,
+				Cleanup:   20,
+				Backend:   memoryBackend,
+				StatusTTLOverrides: map[int]int{
+					http.StatusInternalServerError: 2,
+					http.StatusNotFound:            60,
+					http.StatusGone:                0,
+				},
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			clk := newFakeClock()
+			setClock(c, clk)
+
+			serve := func() {
+				c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			serve()
+
+			if test.wantTTL == 0 {
+				serve()
+				if calls != 2 {
+					t.Errorf("expected the response not to be stored, got %d next handler calls", calls)
+				}
+				return
+			}
+
+			clk.Advance(test.wantTTL - time.Nanosecond)
+			serve()
+
+			if calls != 1 {
+				t.Errorf("expected entry to be fresh before %s, got %d next handler calls", test.wantTTL, calls)
+			}
+
+			clk.Advance(time.Nanosecond)
+			serve()
+
+			if calls != 2 {
+				t.Errorf("expected entry to expire after %s, got %d next handler calls", test.wantTTL, calls)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPStoredHeaders(t *testing.T) {
+	hopByHop := []string{
+		"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Connection", "TE", "Trailer",
+		"Transfer-Encoding", "Upgrade", "X-Connection-Specific",
+	}
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Content-Type", "text/plain")
+		for _, name := range hopByHop {
+			rw.Header().Set(name, "value")
+		}
+		rw.Header().Set("Connection", "keep-alive, x-connection-specific")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := c.get(c.cacheKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range append(hopByHop, cacheHeader) {
+		if v := data.Headers.Get(name); v != "" {
+			t.Errorf("expected %s not to be stored, got %q", name, v)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	for _, name := range hopByHop {
+		if v := rw.Header().Get(name); v != "" {
+			t.Errorf("expected %s not to be replayed, got %q", name, v)
+		}
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("unexpected content type: want \"text/plain\", got: %q", ct)
+	}
+	if got := rw.Header().Values(cacheHeader); len(got) != 1 {
+		t.Errorf("expected a single Cache-Status header, got %q", got)
+	}
+}
+
+func TestCache_ServeHTTPStripAndKeepHeaders(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Date", "Mon, 12 Oct 2026 10:00:00 GMT")
+		rw.Header().Set("X-Trace-Id", "abc")
+		rw.Header().Set("X-Vendor", "value")
+		rw.Header().Set("Keep-Alive", "timeout=5")
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{
+		MaxExpiry:    10,
+		Cleanup:      20,
+		Backend:      memoryBackend,
+		StripHeaders: []string{"x-trace-id"},
+		KeepHeaders:  []string{"date", "Keep-Alive"},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := c.get(c.cacheKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"Date":       "Mon, 12 Oct 2026 10:00:00 GMT",
+		"X-Trace-Id": "",
+		"X-Vendor":   "value",
+		"Keep-Alive": "",
+	}
+	for name, v := range want {
+		if got := data.Headers.Get(name); got != v {
+			t.Errorf("unexpected stored %s: want %q, got %q", name, v, got)
+		}
+	}
+}
+
+func TestCache_ServeHTTPOriginHeaders(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("X-Origin", "origin")
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, AddStatusHeader: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	// A middleware in front of the plugin sets a header of its own.
+	outer := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Request-Id", "request-1")
+		c.ServeHTTP(rw, req)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	rw := httptest.NewRecorder()
+	outer.ServeHTTP(rw, req)
+
+	for name, want := range map[string]string{"X-Request-Id": "request-1", "X-Origin": "origin"} {
+		if got := rw.Header().Get(name); got != want {
+			t.Errorf("unexpected %s of the response: want %q, got %q", name, want, got)
+		}
+	}
+
+	data, err := c.get(c.cacheKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := http.Header{"Cache-Control": {"max-age=20"}, "X-Origin": {"origin"}}
+	if !reflect.DeepEqual(data.Headers, want) {
+		t.Errorf("unexpected stored headers: want %v, got %v", want, data.Headers)
+	}
+}
+
+func TestCache_ServeHTTPImplicitStatus(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("X-Foo", "foo")
+		rw.Header().Set("Location", "/other/path")
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"simplecache; fwd=miss; stored", "simplecache; hit; ttl=10"} {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if rw.Code != http.StatusOK || rw.Body.Len() != 0 {
+			t.Errorf("unexpected response: got status %d and body %q", rw.Code, rw.Body.String())
+		}
+		for name, val := range map[string]string{"X-Foo": "foo", "Location": "/other/path", cacheHeader: want} {
+			if got := rw.Header().Get(name); got != val {
+				t.Errorf("unexpected %s of the response: want %q, got %q", name, val, got)
+			}
+		}
+	}
+}
+
+func TestCache_ServeHTTPHead(t *testing.T) {
+	dir := createTempDir(t)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.WriteHeader(http.StatusOK)
+		if req.Method != http.MethodHead {
+			_, _ = rw.Write([]byte("body"))
+		}
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodHead, "http://localhost/some/path", nil))
+
+	if state := rw.Header().Get("Cache-Status"); state != "miss" {
+		t.Errorf("unexpected cache state: want \"miss\", got: %q", state)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	if calls != 2 {
+		t.Fatalf("expected HEAD response not to be stored, got %d next handler calls", calls)
+	}
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodHead, "http://localhost/some/path", nil))
+
+	if calls != 2 {
+		t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+	}
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want \"hit\", got: %q", state)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("unexpected content type: want \"text/plain\", got: %q", ct)
+	}
+	if rw.Code != http.StatusOK {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusOK, rw.Code)
+	}
+	if rw.Body.Len() != 0 {
+		t.Errorf("unexpected body for HEAD request: %q", rw.Body.String())
+	}
+}
+
+func TestCache_ServeHTTPRequestCacheControl(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		wantCalls int
+		wantBody  string
+	}{
+		{
+			name:      "should refresh the entry on Cache-Control: no-cache",
+			header:    "Cache-Control",
+			value:     "no-cache",
+			wantCalls: 2,
+			wantBody:  "v2",
+		},
+		{
+			name:      "should refresh the entry on Pragma: no-cache",
+			header:    "Pragma",
+			value:     "no-cache",
+			wantCalls: 2,
+			wantBody:  "v2",
+		},
+		{
+			name:      "should neither read nor write the entry on no-store",
+			header:    "Cache-Control",
+			value:     "no-store",
+			wantCalls: 2,
+			wantBody:  "v1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.WriteHeader(http.StatusOK)
+				_, _ = fmt.Fprintf(rw, "v%d", calls)
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			req.Header.Set(test.header, test.value)
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if body := rw.Body.String(); body != "v2" {
+				t.Errorf("unexpected body: want %q, got %q", "v2", body)
+			}
+
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected stored body: want %q, got %q", test.wantBody, body)
+			}
+		})
+	}
+}
+
+// discardWriter is a ResponseWriter that drops everything written to it.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkCache_ServeLarge(b *testing.B) {
+	body := bytes.Repeat([]byte("a"), 8<<20)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=60")
+		_, _ = rw.Write(body)
+	}
+
+	cfg := &Config{Path: createTempDir(b), MaxExpiry: 60, Cleanup: -1}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/large", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+	key := c.cacheKey(req)
+
+	paths := []struct {
+		name string
+		get  func(key string) (*cacheData, error)
+	}{
+		{name: "buffered", get: c.get},
+		{name: "streamed", get: c.open},
+	}
+
+	for _, path := range paths {
+		b.Run(path.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				data, err := path.get(key)
+				if err != nil {
+					b.Fatal(err)
+				}
+				c.serve(&discardWriter{header: make(http.Header)}, req, key, data, cacheStatus{hit: true})
+				data.close()
+			}
+		})
+	}
+}
+
+func BenchmarkCache_ServeChunked(b *testing.B) {
+	chunk := bytes.Repeat([]byte("a"), 4<<10)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "no-store")
+		for i := 0; i < 16; i++ {
+			_, _ = rw.Write(chunk)
+		}
+	}
+
+	cfg := &Config{Path: createTempDir(b), MaxExpiry: 60, Cleanup: -1}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/chunked", nil)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		c.ServeHTTP(&discardWriter{header: make(http.Header)}, req)
+	}
+}
+
+func createTempDir(tb testing.TB) string {
+	tb.Helper()
+
+	dir, err := ioutil.TempDir("./", "example")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() {
+		if err = os.RemoveAll(dir); err != nil {
+			tb.Fatal(err)
+		}
+	})
+
+	return dir
+}
+
+func createTempFile(tb testing.TB) string {
+	tb.Helper()
+
+	p := filepath.Join(createTempDir(tb), "file")
+	if err := ioutil.WriteFile(p, nil, 0600); err != nil {
+		tb.Fatal(err)
+	}
+
+	return p
+}
+
+// countEntries returns the number of file cache entries stored under dir.
+func countEntries(tb testing.TB, dir string) int {
+	tb.Helper()
+
+	var n int
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == metaSuffix {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return n
+}
+
+func TestCache_ServeHTTPAge(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		wantAge  string
+	}{
+		{
+			name:    "should count the seconds since the response was stored",
+			wantAge: "7",
+		},
+		{
+			name:     "should add the age of the upstream response",
+			upstream: "30",
+			wantAge:  "37",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=60")
+				if test.upstream != "" {
+					rw.Header().Set("Age", test.upstream)
+				}
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			clk := newFakeClock()
+			setClock(c, clk)
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if got := rw.Header().Get("Age"); got != test.upstream {
+				t.Errorf("unexpected Age header on a miss: want %q, got %q", test.upstream, got)
+			}
+
+			clk.Advance(7*time.Second + 500*time.Millisecond)
+
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if got := rw.Header().Values("Age"); len(got) != 1 || got[0] != test.wantAge {
+				t.Errorf("unexpected Age header on a hit: want %q, got %q", test.wantAge, got)
+			}
+		})
+	}
+}
+
+func TestCache_CacheableSharedMaxAge(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		wantTTL      time.Duration
+	}{
+		{
+			name:         "should use max-age alone",
+			cacheControl: "max-age=30",
+			wantTTL:      30 * time.Second,
+		},
+		{
+			name:         "should prefer a shorter s-maxage",
+			cacheControl: "s-maxage=5, max-age=60",
+			wantTTL:      5 * time.Second,
+		},
+		{
+			name:         "should prefer a longer s-maxage",
+			cacheControl: "max-age=5, s-maxage=60",
+			wantTTL:      60 * time.Second,
+		},
+		{
+			name:         "should cap s-maxage by maxExpiry",
+			cacheControl: "public, s-maxage=500, max-age=5",
+			wantTTL:      100 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &cache{cfg: &Config{MaxExpiry: 100}, codes: cacheableCodes([]int{http.StatusOK})}
+
+			h := make(http.Header)
+			h.Set("Cache-Control", test.cacheControl)
+
+			ttl, ok := c.cacheable(httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil), h, http.StatusOK)
+			if !ok {
+				t.Fatal("expected the response to be cacheable")
+			}
+			if ttl != test.wantTTL {
+				t.Errorf("unexpected ttl: want %s, got %s", test.wantTTL, ttl)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPExpiryJitter(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=100")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{MaxExpiry: 300, Cleanup: 600, Backend: memoryBackend, ExpiryJitter: 20}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	clk := newFakeClock()
+	setClock(c, clk)
+	c.rng = rand.New(rand.NewSource(1))
+
+	minExpiry, maxExpiry := time.Duration(1<<62), time.Duration(0)
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost/some/path/%d", i), nil)
+		c.ServeHTTP(httptest.NewRecorder(), req)
+
+		data, err := c.get(c.cacheKey(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expiry := data.Expires.Sub(clk.Now())
+		if expiry < 80*time.Second || expiry > 100*time.Second {
+			t.Fatalf("expected expiry within the jitter range, got %s", expiry)
+		}
+		if expiry < minExpiry {
+			minExpiry = expiry
+		}
+		if expiry > maxExpiry {
+			maxExpiry = expiry
+		}
+	}
+
+	if minExpiry > 85*time.Second || maxExpiry < 95*time.Second {
+		t.Errorf("expected expiries to spread across the jitter range, got %s to %s", minExpiry, maxExpiry)
+	}
+}
+
+func TestCache_ServeHTTPNoCachePaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantCalls  int
+		wantStatus string
+	}{
+		{
+			name:       "should bypass the cache on a matching path",
+			path:       "/admin/users",
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=bypass",
+		},
+		{
+			name:       "should cache other paths",
+			path:       "/some/admin",
+			wantCalls:  1,
+			wantStatus: "simplecache; hit; ttl=20",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				MaxExpiry:       100,
+				Cleanup:         200,
+				AddStatusHeader: true,
+				Backend:         memoryBackend,
+				NoCachePaths:    []string{"^/admin/", "^/login$"},
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			setClock(h.(*cache), newFakeClock())
+
+			var rw *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost"+test.path, nil))
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if got := rw.Header().Get(cacheHeader); got != test.wantStatus {
+				t.Errorf("unexpected cache status: want %q, got %q", test.wantStatus, got)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPCachePathsOnly(t *testing.T) {
+	tests := []struct {
+		name      string
+		only      []string
+		path      string
+		wantCalls int
+	}{
+		{
+			name:      "should cache a path in the allowlist",
+			only:      []string{"^/static/", "^/api/v1/products/"},
+			path:      "/static/app.js",
+			wantCalls: 1,
+		},
+		{
+			name:      "should not cache a path missing from the allowlist",
+			only:      []string{"^/static/", "^/api/v1/products/"},
+			path:      "/api/v1/orders/1",
+			wantCalls: 2,
+		},
+		{
+			name:      "should prefer the bypass list",
+			only:      []string{"^/static/"},
+			path:      "/static/private/app.js",
+			wantCalls: 2,
+		},
+		{
+			name:      "should cache all paths without an allowlist",
+			path:      "/api/v1/orders/1",
+			wantCalls: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				MaxExpiry:      100,
+				Cleanup:        200,
+				Backend:        memoryBackend,
+				NoCachePaths:   []string{"^/static/private/"},
+				CachePathsOnly: test.only,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+test.path, nil))
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
+
+// failingBackend is a backend whose writes always fail, like a full disk.
+type failingBackend struct {
+	backend
+	sets int
+}
+
+func (b *failingBackend) Set(string, []byte, time.Duration) error {
+	b.sets++
+	return errors.New("no space left on device")
+}
+
+func TestCache_ServeHTTPFailingSet(t *testing.T) {
+	body := bytes.Repeat([]byte("some body "), 1000)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(body)
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: memoryBackend}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	clk := newFakeClock()
+	setClock(c, clk)
+
+	fb := &failingBackend{backend: c.cache}
+	c.cache = fb
+
+	serve := func() {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if rw.Code != http.StatusOK || !bytes.Equal(rw.Body.Bytes(), body) {
+			t.Errorf("unexpected response: got status %d and %d body bytes", rw.Code, rw.Body.Len())
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		serve()
+	}
+
+	if calls != 3 {
+		t.Errorf("unexpected next handler calls: want 3, got %d", calls)
+	}
+	if fb.sets != 1 {
+		t.Errorf("expected writes to be suspended after a failure, got %d sets", fb.sets)
+	}
+
+	clk.Advance(time.Second)
+	serve()
+
+	if fb.sets != 2 {
+		t.Errorf("expected writes to resume after the backoff, got %d sets", fb.sets)
+	}
+	if got := c.Stats().Errors; got != 2 {
+		t.Errorf("unexpected error count: want 2, got %d", got)
+	}
+}
+
+func TestCache_ServeHTTPOverrideHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantCalls  int
+		wantStatus string
+	}{
+		{
+			name:       "should use the TTL override over Cache-Control",
+			headers:    map[string]string{"Cache-Control": "no-store", "X-Cache-TTL": "30"},
+			wantCalls:  1,
+			wantStatus: "simplecache; hit; ttl=30",
+		},
+		{
+			name:       "should cap the TTL override to the max expiry",
+			headers:    map[string]string{"X-Cache-TTL": "1000"},
+			wantCalls:  1,
+			wantStatus: "simplecache; hit; ttl=100",
+		},
+		{
+			name:       "should not cache with a TTL override of zero",
+			headers:    map[string]string{"Cache-Control": "max-age=20", "X-Cache-TTL": "0"},
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=miss",
+		},
+		{
+			name:       "should ignore an invalid TTL override",
+			headers:    map[string]string{"Cache-Control": "max-age=20", "X-Cache-TTL": "soon"},
+			wantCalls:  1,
+			wantStatus: "simplecache; hit; ttl=20",
+		},
+		{
+			name:       "should not cache with the no cache header",
+			headers:    map[string]string{"Cache-Control": "max-age=20", "X-Cache-TTL": "30", "X-No-Cache": "1"},
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=miss",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				for name, val := range test.headers {
+					rw.Header().Set(name, val)
+				}
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				MaxExpiry:         100,
+				Cleanup:           200,
+				AddStatusHeader:   true,
+				Backend:           memoryBackend,
+				TTLOverrideHeader: "X-Cache-TTL",
+				NoCacheHeader:     "X-No-Cache",
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			setClock(h.(*cache), newFakeClock())
+
+			var rw *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if got := rw.Header().Get(cacheHeader); got != test.wantStatus {
+				t.Errorf("unexpected cache status: want %q, got %q", test.wantStatus, got)
+			}
+			if calls == 1 && rw.Header().Get("X-Cache-TTL") != "" {
+				t.Errorf("expected the TTL override header not to be stored, got %q", rw.Header().Get("X-Cache-TTL"))
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPContentLength(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength string
+		wantCalls     int
+	}{
+		{
+			name:          "should cache a body matching its Content-Length",
+			contentLength: "9",
+			wantCalls:     1,
+		},
+		{
+			name:          "should not cache a body shorter than its Content-Length",
+			contentLength: "100",
+			wantCalls:     2,
+		},
+		{
+			name:          "should not cache an invalid Content-Length",
+			contentLength: "nine",
+			wantCalls:     2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.Header().Set("Content-Length", test.contentLength)
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte("some body"))
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 100, Cleanup: 200}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if test.wantCalls == 2 && countEntries(t, cfg.Path) != 0 {
+				t.Errorf("expected nothing to be cached, got %d entries", countEntries(t, cfg.Path))
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPAbortedResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		next      func(rw http.ResponseWriter, req *http.Request, cancel context.CancelFunc)
+		wantPanic bool
+	}{
+		{
+			name:      "should not cache the response of a panicking handler",
+			wantPanic: true,
+			next: func(rw http.ResponseWriter, req *http.Request, _ context.CancelFunc) {
+				_, _ = rw.Write([]byte("partial"))
+				panic(http.ErrAbortHandler)
+			},
+		},
+		{
+			name: "should not cache the response of a cancelled request",
+			next: func(rw http.ResponseWriter, req *http.Request, cancel context.CancelFunc) {
+				_, _ = rw.Write([]byte("partial"))
+				cancel()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				test.next(rw, req, cancel)
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 100, Cleanup: 200}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serve := func(req *http.Request) (recovered interface{}) {
+				defer func() { recovered = recover() }()
+				h.ServeHTTP(httptest.NewRecorder(), req)
+				return nil
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil).WithContext(ctx)
+			if got := serve(req); (got == http.ErrAbortHandler) != test.wantPanic {
+				t.Fatalf("unexpected panic: want propagated %t, got %v", test.wantPanic, got)
+			}
+
+			if n := countEntries(t, cfg.Path); n != 0 {
+				t.Errorf("expected nothing to be cached, got %d entries", n)
+			}
+
+			cancel()
+			_ = serve(httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if calls != 2 {
+				t.Errorf("expected the next request to reach the handler, got %d next handler calls", calls)
+			}
+		})
+	}
+}
+
+func TestCache_Close(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(c *cache, cancel context.CancelFunc)
+	}{
+		{
+			name:  "should stop when the context is cancelled",
+			close: func(_ *cache, cancel context.CancelFunc) { cancel() },
+		},
+		{
+			name: "should stop when closed",
+			close: func(c *cache, _ context.CancelFunc) {
+				if err := c.Close(); err != nil {
+					t.Errorf("unexpected close error: %v", err)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20}
+
+			h, err := New(ctx, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			test.close(c, cancel)
+
+			done := make(chan struct{})
+			go func() {
+				c.wg.Wait()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected the cleanup goroutine to exit")
+			}
+
+			if err = c.Close(); err != nil {
+				t.Errorf("unexpected error closing twice: %v", err)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPWriteOnly(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = fmt.Fprintf(rw, "v%d", calls)
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true, Backend: memoryBackend, WriteOnly: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	for i := 1; i <= 3; i++ {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if want := fmt.Sprintf("v%d", i); rw.Body.String() != want {
+			t.Errorf("unexpected body of request %d: want %q, got %q", i, want, rw.Body.String())
+		}
+		if want := "simplecache; fwd=bypass; stored; detail=write-only"; rw.Header().Get(cacheHeader) != want {
+			t.Errorf("unexpected cache status of request %d: want %q, got %q", i, want, rw.Header().Get(cacheHeader))
+		}
+
+		data, err := c.get(c.cacheKey(req))
+		if err != nil {
+			t.Fatalf("expected request %d to be stored: %v", i, err)
+		}
+		if want := fmt.Sprintf("v%d", i); string(data.Body) != want {
+			t.Errorf("unexpected stored body after request %d: want %q, got %q", i, want, data.Body)
+		}
+	}
+
+	if stats := c.Stats(); stats.Hits != 0 || stats.Misses != 3 {
+		t.Errorf("unexpected counters: want 0 hits and 3 misses, got %d hits and %d misses", stats.Hits, stats.Misses)
+	}
+}
+
+func TestCache_ServeHTTPReadOnly(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = fmt.Fprintf(rw, "v%d", calls)
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true, Backend: memoryBackend}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	stored := httptest.NewRequest(http.MethodGet, "http://localhost/stored", nil)
+	c.ServeHTTP(httptest.NewRecorder(), stored)
+
+	cfg.ReadOnly = true
+
+	tests := []struct {
+		req        *http.Request
+		wantBody   string
+		wantStatus string
+	}{
+		{req: stored, wantBody: "v1", wantStatus: "simplecache; hit; ttl=20"},
+		{req: httptest.NewRequest(http.MethodGet, "http://localhost/new", nil), wantBody: "v2", wantStatus: "simplecache; fwd=miss"},
+		{req: httptest.NewRequest(http.MethodGet, "http://localhost/new", nil), wantBody: "v3", wantStatus: "simplecache; fwd=miss"},
+	}
+
+	for i, test := range tests {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, test.req)
+
+		if rw.Body.String() != test.wantBody {
+			t.Errorf("unexpected body of request %d: want %q, got %q", i, test.wantBody, rw.Body.String())
+		}
+		if got := rw.Header().Get(cacheHeader); got != test.wantStatus {
+			t.Errorf("unexpected cache status of request %d: want %q, got %q", i, test.wantStatus, got)
+		}
+	}
+
+	if _, err = c.get(c.cacheKey(tests[1].req)); !errors.Is(err, errCacheMiss) {
+		t.Errorf("expected the new response not to be stored, got: %v", err)
+	}
 }
@@ -0,0 +1,15 @@
+package plugin_simplecache
+
+import "time"
+
+// clock tells the current time. Expiry is always computed against a clock so
+// tests can control it.
+type clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,90 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// setClock makes the cache and its backend use clk.
+func setClock(c *cache, clk clock) {
+	c.clock = clk
+
+	switch b := c.cache.(type) {
+	case *fileCache:
+		b.clock = clk
+	case *memoryCache:
+		b.clock = clk
+	}
+}
+
+func TestCache_ServeHTTPExpiresAtDeadline(t *testing.T) {
+	for _, name := range []string{fileBackend, memoryBackend} {
+		t.Run(name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=5")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, Backend: name}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			clk := newFakeClock()
+			setClock(c, clk)
+
+			serve := func() {
+				c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			serve()
+
+			clk.Advance(5*time.Second - time.Nanosecond)
+			serve()
+
+			if calls != 1 {
+				t.Errorf("expected entry to be fresh before its deadline, got %d next handler calls", calls)
+			}
+
+			clk.Advance(time.Nanosecond)
+			serve()
+
+			if calls != 2 {
+				t.Errorf("expected entry to expire at its deadline, got %d next handler calls", calls)
+			}
+		})
+	}
+}
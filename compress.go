@@ -0,0 +1,61 @@
+package plugin_simplecache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// gzipMagic starts every gzip stream. Uncompressed entries start with the
+// length of their metadata, which is never this large, so it tells compressed
+// and uncompressed entries apart.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func compressed(b []byte) bool {
+	return bytes.HasPrefix(b, gzipMagic)
+}
+
+func compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, fmt.Errorf("error compressing cache item: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing cache item: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressReader returns a reader over the payload read from rc, decompressing
+// it if needed. Closing it closes rc.
+func decompressReader(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+
+	magic, _ := br.Peek(len(gzipMagic))
+	if !compressed(magic) {
+		return readCloser{Reader: br, Closer: rc}, nil
+	}
+
+	return gzipReader(readCloser{Reader: br, Closer: rc})
+}
+
+// gzipReader returns a reader decompressing the payload read from rc. Closing it
+// closes rc.
+func gzipReader(rc io.ReadCloser) (io.ReadCloser, error) {
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing cache item: %w", err)
+	}
+
+	return readCloser{Reader: zr, Closer: rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
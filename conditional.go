@@ -0,0 +1,62 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// notModified reports whether the validators of the conditional request r
+// match the stored response headers h, so a 304 can be served in place of the
+// stored body. If-None-Match takes precedence over If-Modified-Since, see RFC
+// 9110 section 13.2.2.
+func notModified(r *http.Request, h http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		return matchETag(inm, h.Get("ETag"))
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+
+	return err == nil && !lm.After(ims)
+}
+
+// matchETag reports whether the If-None-Match values list etag or are "*".
+// Entity tags are compared weakly, as If-None-Match requires.
+func matchETag(values []string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// writeNotModified sends a 304 for the stored headers already set on w. The
+// headers describing the omitted body are removed, see RFC 9110 section 15.4.5.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
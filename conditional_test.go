@@ -0,0 +1,116 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache_ServeHTTPConditional(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantCode int
+	}{
+		{
+			name:     "should serve the body without validators",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "should answer a matching If-None-Match with a 304",
+			header:   http.Header{"If-None-Match": {`"v1"`}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "should serve the body on a non-matching If-None-Match",
+			header:   http.Header{"If-None-Match": {`"v2"`}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "should match an ETag in a list",
+			header:   http.Header{"If-None-Match": {`"v0", "v1"`}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "should compare ETags weakly",
+			header:   http.Header{"If-None-Match": {`W/"v1"`}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "should match any ETag with a wildcard",
+			header:   http.Header{"If-None-Match": {"*"}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "should answer a later If-Modified-Since with a 304",
+			header:   http.Header{"If-Modified-Since": {"Thu, 22 Oct 2015 07:28:00 GMT"}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "should serve the body on an earlier If-Modified-Since",
+			header:   http.Header{"If-Modified-Since": {"Tue, 20 Oct 2015 07:28:00 GMT"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "should prefer If-None-Match over If-Modified-Since",
+			header: http.Header{
+				"If-None-Match":     {`"v2"`},
+				"If-Modified-Since": {"Thu, 22 Oct 2015 07:28:00 GMT"},
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				rw.Header().Set("ETag", `"v1"`)
+				rw.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+				_, _ = rw.Write([]byte("body"))
+			}
+
+			cfg := &Config{MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true, Backend: memoryBackend}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			for name, vals := range test.header {
+				req.Header[name] = vals
+			}
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+
+			if calls != 1 {
+				t.Errorf("unexpected next handler calls: want 1, got %d", calls)
+			}
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+			}
+
+			wantBody, wantType := "body", "text/plain; charset=utf-8"
+			if test.wantCode == http.StatusNotModified {
+				wantBody, wantType = "", ""
+			}
+			if rw.Body.String() != wantBody {
+				t.Errorf("unexpected body: want %q, got %q", wantBody, rw.Body.String())
+			}
+			if got := rw.Header().Get("Content-Type"); got != wantType {
+				t.Errorf("unexpected Content-Type: want %q, got %q", wantType, got)
+			}
+			if got := rw.Header().Get("ETag"); got != `"v1"` {
+				t.Errorf("unexpected ETag: want %q, got %q", `"v1"`, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// mediaTypes returns the set of the media types of types, in lower case and
+// without parameters.
+func mediaTypes(types []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		set[mediaType(t)] = struct{}{}
+	}
+
+	return set
+}
+
+// mediaType returns the media type of the Content-Type value v, ignoring its
+// parameters such as the charset.
+func mediaType(v string) string {
+	if i := strings.IndexByte(v, ';'); i >= 0 {
+		v = v[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// matchMediaType reports whether mt is in set, either listed itself or through
+// a wildcard of its top-level type such as image/*.
+func matchMediaType(set map[string]struct{}, mt string) bool {
+	if _, ok := set[mt]; ok {
+		return true
+	}
+
+	i := strings.IndexByte(mt, '/')
+	if i < 0 {
+		return false
+	}
+	_, ok := set[mt[:i]+"/*"]
+
+	return ok
+}
+
+// cacheableType reports whether responses with the Content-Type of h may be
+// cached. Denied types are never cached and, when allowed types are
+// configured, only those are.
+func (m *cache) cacheableType(h http.Header) bool {
+	mt := mediaType(h.Get("Content-Type"))
+	if matchMediaType(m.noTypes, mt) {
+		return false
+	}
+
+	return len(m.types) == 0 || matchMediaType(m.types, mt)
+}
@@ -0,0 +1,102 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache_ServeHTTPContentTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow       []string
+		deny        []string
+		contentType string
+		wantCalls   int
+	}{
+		{
+			name:        "should cache any type by default",
+			contentType: "application/json",
+			wantCalls:   1,
+		},
+		{
+			name:        "should cache an allowed type",
+			allow:       []string{"text/css", "image/*"},
+			contentType: "image/png",
+			wantCalls:   1,
+		},
+		{
+			name:        "should not cache a type that is not allowed",
+			allow:       []string{"text/css", "image/*"},
+			contentType: "application/json",
+			wantCalls:   2,
+		},
+		{
+			name:        "should not cache a missing type when types are allowed",
+			allow:       []string{"text/css"},
+			contentType: "",
+			wantCalls:   2,
+		},
+		{
+			name:        "should not cache a denied type",
+			deny:        []string{"text/event-stream"},
+			contentType: "text/event-stream",
+			wantCalls:   2,
+		},
+		{
+			name:        "should prefer the denied types",
+			allow:       []string{"application/*"},
+			deny:        []string{"application/json"},
+			contentType: "application/json",
+			wantCalls:   2,
+		},
+		{
+			name:        "should match the media type of a parameterized type",
+			allow:       []string{"Text/HTML"},
+			contentType: "text/html; charset=utf-8",
+			wantCalls:   1,
+		},
+		{
+			name:        "should deny a parameterized type",
+			deny:        []string{"text/html; charset=utf-8"},
+			contentType: "text/html;charset=iso-8859-1",
+			wantCalls:   2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if test.contentType != "" {
+					rw.Header().Set("Content-Type", test.contentType)
+				}
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				MaxExpiry:           100,
+				Cleanup:             200,
+				Backend:             memoryBackend,
+				CacheContentTypes:   test.allow,
+				NoCacheContentTypes: test.deny,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
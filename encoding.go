@@ -0,0 +1,181 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings applied by the middleware.
+const (
+	gzipEncoding   = "gzip"
+	brotliEncoding = "br"
+)
+
+// encodingQuality returns the quality value the Accept-Encoding header of r
+// gives to coding, 0 when it is not acceptable, see RFC 9110 section 12.5.3.
+func encodingQuality(r *http.Request, coding string) float64 {
+	quality := 0.0
+	for _, field := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(field, ",") {
+			name, q := part, ""
+			if i := strings.Index(part, ";"); i >= 0 {
+				name, q = part[:i], part[i+1:]
+			}
+
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != coding && name != "*" {
+				continue
+			}
+
+			v := 1.0
+			if q = strings.TrimSpace(q); strings.HasPrefix(q, "q=") {
+				var err error
+				if v, err = strconv.ParseFloat(q[2:], 64); err != nil {
+					v = 0
+				}
+			}
+
+			// An explicit coding overrides the wildcard.
+			if name == coding {
+				return v
+			}
+			quality = v
+		}
+	}
+
+	return quality
+}
+
+// negotiateEncoding returns the content coding of the responses served to r,
+// the accepted one with the highest quality value, preferring brotli on a tie,
+// or "" when the client accepts neither.
+func negotiateEncoding(r *http.Request) string {
+	br, gz := encodingQuality(r, brotliEncoding), encodingQuality(r, gzipEncoding)
+
+	switch {
+	case br > 0 && br >= gz:
+		return brotliEncoding
+	case gz > 0:
+		return gzipEncoding
+	default:
+		return ""
+	}
+}
+
+// compressible reports whether the stored response data may be compressed when
+// served, which excludes responses already carrying a content coding.
+func (m *cache) compressible(data *cacheData) bool {
+	return m.cfg.CompressResponses && data.Status == http.StatusOK && data.Headers.Get("Content-Encoding") == ""
+}
+
+// encoded returns the stored response data for key compressed with brotli or
+// gzip, as negotiated with the client. The compressed variants are stored next
+// to the entry so they are only compressed once, and are recomputed when the
+// entry is stored again.
+func (m *cache) encoded(r *http.Request, key string, data *cacheData) *cacheData {
+	coding := negotiateEncoding(r)
+	if coding == "" {
+		return data
+	}
+
+	if data.Key != "" {
+		key = data.Key
+	}
+	ekey := key + "|encoding:" + coding
+
+	if enc, err := m.get(ekey); err == nil && enc.Stored.Equal(data.Stored) {
+		data.close()
+		return enc
+	}
+
+	if err := data.load(); err != nil {
+		m.logger.Error("Error reading cache item", "key", key, "error", err)
+		return data
+	}
+
+	body, err := compressWith(coding, data.Body)
+	if err != nil {
+		m.logger.Error("Error compressing response", "key", key, "error", err)
+		return data
+	}
+
+	enc := *data
+	enc.Body = body
+	enc.Headers = data.Headers.Clone()
+	enc.Headers.Set("Content-Encoding", coding)
+	enc.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	// The compressed body is a different representation, only weakly
+	// equivalent to the stored one.
+	weakenETag(enc.Headers)
+
+	m.storeEncoded(ekey, data, enc)
+
+	return &enc
+}
+
+// storeEncoded stores the compressed variant enc of the entry data under ekey,
+// for as long as data is kept. Like any response, it is not stored in read only
+// mode or while writes are suspended, it is then compressed again next time.
+func (m *cache) storeEncoded(ekey string, data *cacheData, enc cacheData) {
+	if m.cfg.ReadOnly || data.Expires.IsZero() {
+		return
+	}
+
+	now := m.clock.Now()
+	if m.backoff.suspended(now) {
+		m.logger.Debug("Writes are suspended after a failure", "key", ekey)
+		return
+	}
+
+	ttl := data.Expires.Sub(now) + m.retention(data)
+	if ttl <= 0 {
+		return
+	}
+
+	if err := m.set(ekey, enc, ttl); err != nil {
+		m.setFailed(ekey, err)
+		return
+	}
+
+	m.backoff.succeeded()
+	atomic.AddUint64(&m.stats.sets, 1)
+}
+
+// compressWith compresses b with coding, one of the content codings applied by
+// the middleware.
+func compressWith(coding string, b []byte) ([]byte, error) {
+	if coding == gzipEncoding {
+		return compress(b)
+	}
+
+	var buf bytes.Buffer
+
+	bw := brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	if _, err := bw.Write(b); err != nil {
+		return nil, fmt.Errorf("error compressing response: %w", err)
+	}
+	if err := bw.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing response: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// addVary adds name to the Vary header of h, unless it is already listed.
+func addVary(h http.Header, name string) {
+	for _, field := range h.Values("Vary") {
+		for _, v := range strings.Split(field, ",") {
+			if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, name) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", name)
+}
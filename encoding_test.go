@@ -0,0 +1,180 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: "gzip"},
+		{acceptEncoding: "br", want: "br"},
+		{acceptEncoding: "gzip, br", want: "br"},
+		{acceptEncoding: "br;q=0.5, GZIP", want: "gzip"},
+		{acceptEncoding: "BR, gzip;q=0.5", want: "br"},
+		{acceptEncoding: "deflate", want: ""},
+		{acceptEncoding: "gzip;q=0", want: ""},
+		{acceptEncoding: "br;q=0, gzip", want: "gzip"},
+		{acceptEncoding: "*", want: "br"},
+		{acceptEncoding: "*, br;q=0", want: "gzip"},
+		{acceptEncoding: "*, br;q=0, gzip;q=0", want: ""},
+		{acceptEncoding: "identity", want: ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+
+		if got := negotiateEncoding(req); got != test.want {
+			t.Errorf("unexpected coding for %q: want %q, got %q", test.acceptEncoding, test.want, got)
+		}
+	}
+}
+
+func TestCache_ServeHTTPCompressResponses(t *testing.T) {
+	body := bytes.Repeat([]byte("some compressible body "), 100)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("ETag", `"v1"`)
+		_, _ = rw.Write(body)
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: memoryBackend, CompressResponses: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{acceptEncoding: "", wantEncoding: ""},
+		{acceptEncoding: "br;q=0.5, gzip", wantEncoding: "gzip"},
+		{acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{acceptEncoding: "br, gzip", wantEncoding: "br"},
+		{acceptEncoding: "br", wantEncoding: "br"},
+		{acceptEncoding: "deflate", wantEncoding: ""},
+		{acceptEncoding: "", wantEncoding: ""},
+	}
+
+	for i, test := range requests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		// The first response is served by the origin.
+		if vary := rw.Header().Get("Vary"); i > 0 && vary != "Accept-Encoding" {
+			t.Errorf("unexpected vary for %q: want \"Accept-Encoding\", got %q", test.acceptEncoding, vary)
+		}
+
+		if encoding := rw.Header().Get("Content-Encoding"); encoding != test.wantEncoding {
+			t.Errorf("unexpected content encoding for %q: want %q, got %q", test.acceptEncoding, test.wantEncoding, encoding)
+		}
+
+		got := rw.Body.Bytes()
+		if test.wantEncoding != "" {
+			var zr io.Reader = brotli.NewReader(rw.Body)
+			if test.wantEncoding == gzipEncoding {
+				if zr, err = gzip.NewReader(rw.Body); err != nil {
+					t.Fatalf("unexpected gzip error for %q: %v", test.acceptEncoding, err)
+				}
+			}
+			if got, err = ioutil.ReadAll(zr); err != nil {
+				t.Fatalf("unexpected %s error for %q: %v", test.wantEncoding, test.acceptEncoding, err)
+			}
+			if etag := rw.Header().Get("ETag"); etag != `W/"v1"` {
+				t.Errorf("unexpected etag for %q: want %q, got %q", test.acceptEncoding, `W/"v1"`, etag)
+			}
+		}
+		if !bytes.Equal(got, body) {
+			t.Errorf("unexpected body for %q", test.acceptEncoding)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("unexpected next handler calls: want 1, got %d", calls)
+	}
+	if entries, _ := c.(*cache).cache.(sizer).usage(); entries != 3 {
+		t.Errorf("unexpected stored entries: want 3, got %d", entries)
+	}
+	if sets := c.(*cache).Stats().Sets; sets != 3 {
+		t.Errorf("unexpected sets: want 3, got %d", sets)
+	}
+}
+
+func TestCache_ServeHTTPCompressResponsesWithoutWrites(t *testing.T) {
+	tests := []struct {
+		name      string
+		readOnly  bool
+		suspended bool
+	}{
+		{name: "should not store the compressed variant in read only mode", readOnly: true},
+		{name: "should not store the compressed variant while writes are suspended", suspended: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte("some compressible body "), 100)
+
+			cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: memoryBackend, CompressResponses: true, ReadOnly: test.readOnly}
+
+			h, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			now := c.clock.Now()
+			data := cacheData{Status: http.StatusOK, Headers: http.Header{}, Body: body, Stored: now, Expires: now.Add(time.Minute)}
+			if err = c.set(c.cacheKey(req), data, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if test.suspended {
+				c.backoff.failed(now)
+			}
+
+			for i := 0; i < 2; i++ {
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+
+				if encoding := rw.Header().Get("Content-Encoding"); encoding != gzipEncoding {
+					t.Errorf("unexpected content encoding: want %q, got %q", gzipEncoding, encoding)
+				}
+			}
+
+			if entries, _ := c.cache.(sizer).usage(); entries != 1 {
+				t.Errorf("unexpected stored entries: want 1, got %d", entries)
+			}
+			if sets := c.Stats().Sets; sets != 0 {
+				t.Errorf("unexpected sets: want 0, got %d", sets)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package plugin_simplecache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// errListUnsupported is returned when the backend cannot list its entries.
+var errListUnsupported = errors.New("backend does not support listing entries")
+
+// entryInfo describes a stored response.
+type entryInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	Status  int       `json:"status"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+}
+
+// Entries returns the responses currently stored, sorted by key. Entries stored
+// by earlier versions have an empty key, as it was not recorded.
+func (m *cache) Entries() ([]entryInfo, error) {
+	l, ok := m.cache.(lister)
+	if !ok {
+		return nil, errListUnsupported
+	}
+
+	return l.entries()
+}
+
+// serveEntries writes the stored responses as JSON, when the request carries
+// the purge token.
+func (m *cache) serveEntries(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(purgeTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(m.cfg.PurgeAuthToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	entries, err := m.Entries()
+	switch {
+	case errors.Is(err, errListUnsupported):
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	case err != nil:
+		m.logger.Error("Error listing cache entries", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if entries == nil {
+		entries = []entryInfo{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,143 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_Entries(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		if req.URL.Path == "/varied" {
+			rw.Header().Set("Vary", "Accept-Language")
+		}
+		if req.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = rw.Write([]byte("content"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 100, Cleanup: 200}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+	clock := newFakeClock()
+	setClock(c, clock)
+
+	var reqs []*http.Request
+	for _, path := range []string{"/some/path", "/missing", "/varied"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+		c.ServeHTTP(httptest.NewRecorder(), req)
+		reqs = append(reqs, req)
+	}
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		c.cacheKey(reqs[0]): http.StatusOK,
+		c.cacheKey(reqs[1]): http.StatusNotFound,
+		varyKey(c.cacheKey(reqs[2]), []string{"Accept-Language"}, reqs[2]): http.StatusOK,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("unexpected entries: want %d, got %+v", len(want), entries)
+	}
+	for i, e := range entries {
+		if i > 0 && entries[i-1].Key > e.Key {
+			t.Errorf("unexpected entry order: %q before %q", entries[i-1].Key, e.Key)
+		}
+		if status, ok := want[e.Key]; !ok || status != e.Status {
+			t.Errorf("unexpected entry %+v", e)
+		}
+		if e.Size <= int64(len("content")) {
+			t.Errorf("unexpected size of %q: %d", e.Key, e.Size)
+		}
+		if !e.Stored.Equal(clock.Now()) || !e.Expires.Equal(clock.Now().Add(20*time.Second)) {
+			t.Errorf("unexpected times of %q: stored %v, expires %v", e.Key, e.Stored, e.Expires)
+		}
+	}
+}
+
+func TestCache_ServeHTTPEntries(t *testing.T) {
+	tests := []struct {
+		name      string
+		backend   string
+		token     string
+		wantCode  int
+		wantCount int
+	}{
+		{
+			name:     "should reject a request without the token",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:      "should list the entries",
+			token:     "secret",
+			wantCode:  http.StatusOK,
+			wantCount: 1,
+		},
+		{
+			name:     "should report a backend that cannot list entries",
+			backend:  memoryBackend,
+			token:    "secret",
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=20")
+				_, _ = rw.Write([]byte("content"))
+			}
+
+			cfg := &Config{
+				Path:           createTempDir(t),
+				MaxExpiry:      100,
+				Cleanup:        200,
+				Backend:        test.backend,
+				PurgeAuthToken: "secret",
+				EntriesPath:    "/_cache/entries",
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/_cache/entries", nil)
+			if test.token != "" {
+				req.Header.Set(purgeTokenHeader, test.token)
+			}
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantCode {
+				t.Fatalf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+
+			var entries []entryInfo
+			if err = json.Unmarshal(rw.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != test.wantCount || entries[0].Key != "simplecache:GETlocalhost/some/path?" {
+				t.Errorf("unexpected entries: %+v", entries)
+			}
+		})
+	}
+}
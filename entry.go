@@ -0,0 +1,189 @@
+package plugin_simplecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Entries are encoded as the big-endian length of their JSON metadata, the
+// metadata and the raw body, so the body can be streamed without decoding it
+// and is not inflated by a base64 encoding. See BenchmarkEntry.
+const metaLenSize = 4
+
+// maxMetaLen bounds the metadata of an entry, guarding against corrupted
+// lengths.
+const maxMetaLen = 1 << 24
+
+var errMalformedEntry = errors.New("malformed cache entry")
+
+// entryCodec encodes cache entries to the values stored by the backends.
+type entryCodec interface {
+	marshal(data *cacheData) ([]byte, error)
+	unmarshal(b []byte) (*cacheData, error)
+}
+
+// entryEncoding is the codec entries are written with.
+var entryEncoding entryCodec = binaryCodec{}
+
+func marshalEntry(data *cacheData) ([]byte, error) {
+	return entryEncoding.marshal(data)
+}
+
+// unmarshalEntry decodes an entry written with any codec. Entries written as
+// JSON by older versions start with a brace, which as the first byte of a
+// length is far above maxMetaLen.
+func unmarshalEntry(b []byte) (*cacheData, error) {
+	if isJSONEntry(b) {
+		return jsonCodec{}.unmarshal(b)
+	}
+
+	return binaryCodec{}.unmarshal(b)
+}
+
+func isJSONEntry(b []byte) bool {
+	return len(b) > 0 && b[0] == '{'
+}
+
+// binaryCodec encodes entries as the length of their JSON metadata, the
+// metadata and the raw body.
+type binaryCodec struct{}
+
+func (binaryCodec) marshal(data *cacheData) ([]byte, error) {
+	meta, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, metaLenSize, metaLenSize+len(meta)+len(data.Body))
+	binary.BigEndian.PutUint32(b, uint32(len(meta)))
+	b = append(b, meta...)
+
+	return append(b, data.Body...), nil
+}
+
+func (binaryCodec) unmarshal(b []byte) (*cacheData, error) {
+	if len(b) < metaLenSize {
+		return nil, errMalformedEntry
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	if n > maxMetaLen || uint64(len(b)-metaLenSize) < uint64(n) {
+		return nil, errMalformedEntry
+	}
+
+	var data cacheData
+	if err := json.Unmarshal(b[metaLenSize:metaLenSize+n], &data); err != nil {
+		return nil, err
+	}
+	data.Body = b[metaLenSize+n:]
+
+	return &data, nil
+}
+
+// jsonCodec encodes entries as a single JSON object, with a base64 encoded
+// body, like older versions did.
+type jsonCodec struct{}
+
+type jsonEntry struct {
+	cacheData
+	Body []byte
+}
+
+func (jsonCodec) marshal(data *cacheData) ([]byte, error) {
+	return json.Marshal(jsonEntry{cacheData: *data, Body: data.Body})
+}
+
+func (jsonCodec) unmarshal(b []byte) (*cacheData, error) {
+	var e jsonEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	e.cacheData.Body = e.Body
+
+	return &e.cacheData, nil
+}
+
+// splitEntry splits an encoded entry into its length prefixed metadata and its
+// body. Values that are not encoded entries are all body.
+func splitEntry(b []byte) (head, body []byte) {
+	if len(b) < metaLenSize {
+		return nil, b
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	if n > maxMetaLen || uint64(len(b)-metaLenSize) < uint64(n) {
+		return nil, b
+	}
+
+	return b[:metaLenSize+n], b[metaLenSize+n:]
+}
+
+// readEntry decodes the metadata of an entry from r, leaving the body to be
+// read from data.body.
+func readEntry(r io.ReadCloser) (*cacheData, error) {
+	br := bufio.NewReader(r)
+
+	if b, err := br.Peek(1); err == nil && isJSONEntry(b) {
+		defer func() { _ = r.Close() }()
+
+		b, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cache item: %w", err)
+		}
+		return jsonCodec{}.unmarshal(b)
+	}
+
+	var hdr [metaLenSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, errMalformedEntry
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxMetaLen {
+		return nil, errMalformedEntry
+	}
+
+	meta := make([]byte, n)
+	if _, err := io.ReadFull(br, meta); err != nil {
+		return nil, errMalformedEntry
+	}
+
+	var data cacheData
+	if err := json.Unmarshal(meta, &data); err != nil {
+		return nil, err
+	}
+	data.body = readCloser{Reader: br, Closer: r}
+
+	return &data, nil
+}
+
+// load reads a streamed body into memory.
+func (d *cacheData) load() error {
+	if d.body == nil {
+		return nil
+	}
+	defer d.close()
+
+	b, err := ioutil.ReadAll(d.body)
+	if err != nil {
+		return fmt.Errorf("error reading cache item: %w", err)
+	}
+	d.Body = b
+
+	return nil
+}
+
+// close releases a streamed body that was not read.
+func (d *cacheData) close() {
+	if d == nil || d.body == nil {
+		return
+	}
+
+	_ = d.body.Close()
+	d.body = nil
+}
@@ -0,0 +1,146 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEntry_RoundTrip(t *testing.T) {
+	data := &cacheData{
+		Status:  http.StatusOK,
+		Headers: http.Header{"Content-Type": {"application/octet-stream"}},
+		Body:    []byte{0x00, 0x1f, 0x8b, 0xff},
+	}
+
+	b, err := marshalEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unmarshalEntry(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != data.Status || got.Headers.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected metadata: %+v", got)
+	}
+	if !bytes.Equal(got.Body, data.Body) {
+		t.Errorf("unexpected body: want %v, got %v", data.Body, got.Body)
+	}
+
+	streamed, err := readEntry(ioutil.NopCloser(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed.Body != nil {
+		t.Errorf("expected body not to be read with the metadata, got %v", streamed.Body)
+	}
+	if err = streamed.load(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed.Body, data.Body) {
+		t.Errorf("unexpected streamed body: want %v, got %v", data.Body, streamed.Body)
+	}
+}
+
+func TestEntry_Malformed(t *testing.T) {
+	for _, b := range [][]byte{nil, {0x00, 0x00}, {0x00, 0x00, 0x00, 0x10, '{'}, {0xff, 0xff, 0xff, 0xff}} {
+		if _, err := unmarshalEntry(b); !errors.Is(err, errMalformedEntry) {
+			t.Errorf("unexpected error for %v: want %v, got %v", b, errMalformedEntry, err)
+		}
+	}
+}
+
+func TestEntry_LegacyJSON(t *testing.T) {
+	// Older versions stored the whole entry as JSON, with a base64 encoded body.
+	legacy, err := json.Marshal(struct {
+		Status  int
+		Headers http.Header
+		Body    []byte
+	}{
+		Status:  http.StatusOK,
+		Headers: http.Header{"Content-Type": {"text/plain"}},
+		Body:    []byte("some body"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unmarshalEntry(legacy)
+	if err != nil {
+		t.Fatalf("unexpected error decoding a legacy entry: %v", err)
+	}
+	if got.Status != http.StatusOK || got.Headers.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected metadata: %+v", got)
+	}
+	if string(got.Body) != "some body" {
+		t.Errorf("unexpected body: want %q, got %q", "some body", got.Body)
+	}
+
+	streamed, err := readEntry(ioutil.NopCloser(bytes.NewReader(legacy)))
+	if err != nil {
+		t.Fatalf("unexpected error reading a legacy entry: %v", err)
+	}
+	if err = streamed.load(); err != nil {
+		t.Fatal(err)
+	}
+	if string(streamed.Body) != "some body" {
+		t.Errorf("unexpected streamed body: want %q, got %q", "some body", streamed.Body)
+	}
+}
+
+// BenchmarkEntry compares the entry encoding, which stores the body raw after
+// the JSON metadata, with encoding the whole entry as JSON, which base64
+// encodes the body.
+func BenchmarkEntry(b *testing.B) {
+	data := &cacheData{
+		Status:  http.StatusOK,
+		Headers: http.Header{"Content-Type": {"application/octet-stream"}, "Etag": {`"v1"`}},
+		Body:    bytes.Repeat([]byte{0x00, 0x1f, 0x8b, 0xff}, 64<<10),
+		Expires: time.Unix(1600000000, 0),
+		Stored:  time.Unix(1600000000, 0),
+	}
+
+	codecs := []struct {
+		name  string
+		codec entryCodec
+	}{
+		{name: "entry", codec: binaryCodec{}},
+		{name: "json", codec: jsonCodec{}},
+	}
+
+	for _, test := range codecs {
+		codec := test.codec
+
+		encoded, err := codec.marshal(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(test.name+"/marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(encoded)), "bytes/entry")
+
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.marshal(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(test.name+"/unmarshal", func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.unmarshal(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
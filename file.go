@@ -1,141 +1,662 @@
 package plugin_simplecache
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// tmpFilePrefix prefixes the files entries are written to before being renamed
+// into place.
+const tmpFilePrefix = ".tmp-"
+
+const (
+	defaultDirMode  os.FileMode = 0700
+	defaultFileMode os.FileMode = 0600
+)
+
+// Every entry is stored as a metadata file next to a body file. The metadata
+// file holds the expiry of the entry, the size of its body file, the CRC-32 of
+// the encoded entry metadata and body file, and the encoded entry metadata. The
+// body file holds the raw, possibly compressed, body.
+const (
+	metaSuffix     = ".meta"
+	bodySuffix     = ".body"
+	metaHeaderSize = 20
+)
+
+// The top bits of the body size tell whether the body file is compressed.
+// Entries written by earlier versions have neither bit set, and are told apart
+// by their content.
+const (
+	bodyCompressed uint64 = 1 << 63
+	bodyRaw        uint64 = 1 << 62
+	bodySizeMask          = bodyRaw - 1
+)
+
 var errCacheMiss = errors.New("cache miss")
 
 type fileCache struct {
-	path string
-	pm   *pathMutex
+	evictionCounter
+
+	path     string
+	pm       *pathMutex
+	idx      *fileIndex
+	clock    clock
+	compress bool
+	// compressMinBytes is the size from which bodies are compressed.
+	compressMinBytes int64
+	dirMode          os.FileMode
+	fileMode         os.FileMode
+
+	// maxBytes is the disk quota of the entries. Zero means unlimited.
+	maxBytes int64
+	// maxEntries is the maximum number of entries. Zero means unlimited.
+	maxEntries int
 }
 
-func newFileCache(path string, vacuum time.Duration) (*fileCache, error) {
+func newFileCache(path string) (*fileCache, error) {
+	if path == "" {
+		return nil, errors.New("path must be set")
+	}
+
+	// Clearing the cache removes everything under the path.
+	if filepath.Dir(filepath.Clean(path)) == filepath.Clean(path) {
+		return nil, fmt.Errorf("path must not be a root directory: %q", path)
+	}
+
 	info, err := os.Stat(path)
 	if err != nil {
 		return nil, fmt.Errorf("invalid cache path: %w", err)
 	}
 
 	if !info.IsDir() {
-		return nil, errors.New("path must be a directory")
+		return nil, fmt.Errorf("path must be a directory: %q", path)
 	}
 
-	fc := &fileCache{
-		path: path,
-		pm:   &pathMutex{lock: make(map[string]*fileLock)},
+	if err = probeWritable(path); err != nil {
+		return nil, fmt.Errorf("path must be writable: %w", err)
 	}
 
-	if vacuum > 0 {
-		go fc.vacuum(vacuum)
+	return &fileCache{
+		path:     path,
+		pm:       &pathMutex{lock: make(map[string]*fileLock)},
+		idx:      loadFileIndex(path),
+		clock:    realClock{},
+		dirMode:  defaultDirMode,
+		fileMode: defaultFileMode,
+	}, nil
+}
+
+// probeWritable checks that files can be created in the directory at path.
+func probeWritable(path string) error {
+	probe, err := ioutil.TempFile(path, tmpFilePrefix)
+	if err != nil {
+		return err
 	}
+	_ = probe.Close()
 
-	return fc, nil
+	return os.Remove(probe.Name())
 }
 
-func (c *fileCache) vacuum(interval time.Duration) {
-	timer := time.NewTicker(interval)
-	defer timer.Stop()
+// check reports whether entries can still be written.
+func (c *fileCache) check() error {
+	if err := probeWritable(c.path); err != nil {
+		return fmt.Errorf("cache path is not writable: %w", err)
+	}
 
-	for range timer.C {
-		_ = filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
-			if err != nil || info.IsDir() {
-				return nil
-			}
+	return nil
+}
 
-			mu := c.pm.MutexAt(filepath.Base(path))
-			mu.Lock()
-			defer mu.Unlock()
+// removeExpired removes the expired entries. Body files without metadata are
+// removed as well, and so are the expired entries left by older versions, which
+// stored every entry as a single file. Other files are left alone.
+func (c *fileCache) removeExpired() {
+	_ = filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), tmpFilePrefix) {
+			return nil
+		}
 
-			f, err := os.Open(filepath.Clean(path))
-			if err != nil {
-				return nil
+		switch filepath.Ext(path) {
+		case metaSuffix:
+			c.removeIfExpired(strings.TrimSuffix(path, metaSuffix))
+		case bodySuffix:
+			p := strings.TrimSuffix(path, bodySuffix)
+			if _, err := os.Stat(p + metaSuffix); os.IsNotExist(err) {
+				c.removeIfExpired(p)
 			}
-
-			var t [8]byte
-			if _, err := f.Read(t[:]); err != nil {
-				_ = f.Close()
-				return nil
+		default:
+			if isLegacyEntry(c.path, path) {
+				c.removeLegacyIfExpired(path)
 			}
-			_ = f.Close()
+		}
+		return nil
+	})
+}
 
-			expires := time.Unix(int64(binary.LittleEndian.Uint64(t[:])), 0)
-			if expires.Before(time.Now()) {
-				_ = os.Remove(path)
-			}
-			return nil
-		})
+// isLegacyEntry reports whether the file at path is an entry of an older
+// version: a file named after the SHA-256 of its key, in four levels of
+// directories named after its first bytes.
+func isLegacyEntry(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 5 {
+		return false
+	}
+
+	name := parts[4]
+	if h, err := hex.DecodeString(name); err != nil || len(h) != sha256.Size || hex.EncodeToString(h) != name {
+		return false
+	}
+	for i, dir := range parts[:4] {
+		if dir != name[2*i:2*i+2] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// removeLegacyIfExpired removes the legacy entry at path if it expired. Such
+// entries start with their expiry as a little-endian Unix timestamp.
+func (c *fileCache) removeLegacyIfExpired(path string) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return
+	}
+
+	var b [8]byte
+	_, err = io.ReadFull(f, b[:])
+	_ = f.Close()
+	if err != nil {
+		return
+	}
+
+	expires := time.Unix(int64(binary.LittleEndian.Uint64(b[:])), 0)
+	if expires.After(c.clock.Now()) {
+		return
+	}
+
+	_ = os.Remove(path)
+}
+
+// removeIfExpired removes the entry at p if it expired or has no metadata.
+func (c *fileCache) removeIfExpired(p string) {
+	mu := c.pm.MutexAt(filepath.Base(p))
+	mu.Lock()
+	defer mu.Unlock()
+
+	hdr, err := readMetaHeader(p)
+	if err == nil && hdr.expires.After(c.clock.Now()) {
+		return
+	}
+
+	if c.remove(p) {
+		c.evicted()
+	}
+}
+
+type metaHeader struct {
+	expires  time.Time
+	bodySize int64
+	checksum uint32
+	coding   uint64
+}
+
+func readMetaHeader(p string) (metaHeader, error) {
+	f, err := os.Open(filepath.Clean(p + metaSuffix))
+	if err != nil {
+		return metaHeader{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var b [metaHeaderSize]byte
+	if _, err = io.ReadFull(f, b[:]); err != nil {
+		return metaHeader{}, err
 	}
+
+	return parseMetaHeader(b[:]), nil
+}
+
+func parseMetaHeader(b []byte) metaHeader {
+	size := binary.LittleEndian.Uint64(b[8:16])
+
+	return metaHeader{
+		expires:  time.Unix(int64(binary.LittleEndian.Uint64(b[:8])), 0),
+		bodySize: int64(size & bodySizeMask),
+		checksum: binary.LittleEndian.Uint32(b[16:20]),
+		coding:   size &^ bodySizeMask,
+	}
+}
+
+// remove removes both files of the entry at p and reports whether its metadata
+// existed. The entry lock must be held.
+func (c *fileCache) remove(p string) bool {
+	err := os.Remove(p + metaSuffix)
+	_ = os.Remove(p + bodySuffix)
+	c.idx.remove(p)
+
+	return err == nil
 }
 
 func (c *fileCache) Get(key string) ([]byte, error) {
-	mu := c.pm.MutexAt(key)
+	rc, err := c.open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache item: %w", err)
+	}
+
+	return b, nil
+}
+
+// open returns a reader over the entry stored for key. Once opened, the entry
+// can be read to the end even if it is replaced or removed in the meantime.
+func (c *fileCache) open(key string) (io.ReadCloser, error) {
+	p := keyPath(c.path, key)
+
+	// Expired and corrupted entries are removed under the write lock once the
+	// read lock is released, checking that they were not replaced meanwhile.
+	rc, meta, err := c.openLocked(p)
+	switch {
+	case errors.Is(err, errEntryExpired):
+		c.removeIfExpired(p)
+		return nil, errCacheMiss
+	case errors.Is(err, errEntryCorrupted):
+		c.removeIfCorrupted(p, meta)
+		return nil, errCacheMiss
+	}
+
+	return rc, err
+}
+
+var (
+	errEntryExpired   = errors.New("entry expired")
+	errEntryCorrupted = errors.New("entry corrupted")
+)
+
+// openLocked opens the entry stored at p under its read lock. With
+// errEntryCorrupted, the metadata which was read is returned too.
+func (c *fileCache) openLocked(p string) (io.ReadCloser, []byte, error) {
+	mu := c.pm.MutexAt(filepath.Base(p))
 	mu.RLock()
 	defer mu.RUnlock()
 
-	p := keyPath(c.path, key)
-	b, err := ioutil.ReadFile(filepath.Clean(p))
+	meta, err := ioutil.ReadFile(filepath.Clean(p + metaSuffix))
+	if err != nil || len(meta) < metaHeaderSize {
+		return nil, nil, errCacheMiss
+	}
+
+	hdr := parseMetaHeader(meta)
+	if !hdr.expires.After(c.clock.Now()) {
+		return nil, nil, errEntryExpired
+	}
+
+	f, err := os.Open(filepath.Clean(p + bodySuffix))
 	if err != nil {
-		return nil, errCacheMiss
+		return nil, nil, errCacheMiss
 	}
 
-	if len(b) < 8 {
-		return nil, errCacheMiss
+	// A body that does not match its metadata, e.g. after a crash between the
+	// writes of both files, is a miss.
+	if info, err := f.Stat(); err != nil || info.Size() != hdr.bodySize {
+		_ = f.Close()
+		return nil, nil, errCacheMiss
 	}
 
-	expires := time.Unix(int64(binary.LittleEndian.Uint64(b[:8])), 0)
-	if expires.Before(time.Now()) {
-		_ = os.Remove(p)
-		return nil, errCacheMiss
+	// A corrupted entry is removed rather than served. The body is checked
+	// before streaming it, as a client cannot be told afterwards.
+	if !verifyChecksum(hdr.checksum, meta[metaHeaderSize:], f) {
+		_ = f.Close()
+		return nil, meta, errEntryCorrupted
 	}
 
-	return b[8:], nil
+	c.idx.touch(p)
+
+	var body io.ReadCloser
+	switch hdr.coding {
+	case bodyCompressed:
+		body, err = gzipReader(f)
+	case bodyRaw:
+		body = f
+	default:
+		body, err = decompressReader(f)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+
+	return readCloser{
+		Reader: io.MultiReader(bytes.NewReader(meta[metaHeaderSize:]), body),
+		Closer: body,
+	}, nil, nil
 }
 
-func (c *fileCache) Set(key string, val []byte, expiry time.Duration) error {
-	mu := c.pm.MutexAt(key)
+// removeIfCorrupted removes the entry stored at p if it still has the metadata
+// meta and its body still fails the checksum.
+func (c *fileCache) removeIfCorrupted(p string, meta []byte) {
+	mu := c.pm.MutexAt(filepath.Base(p))
 	mu.Lock()
 	defer mu.Unlock()
 
+	cur, err := ioutil.ReadFile(filepath.Clean(p + metaSuffix))
+	if err != nil || !bytes.Equal(cur, meta) {
+		return
+	}
+
+	f, err := os.Open(filepath.Clean(p + bodySuffix))
+	if err == nil {
+		ok := verifyChecksum(parseMetaHeader(meta).checksum, meta[metaHeaderSize:], f)
+		_ = f.Close()
+		if ok {
+			return
+		}
+	}
+
+	c.remove(p)
+}
+
+// verifyChecksum reports whether head followed by the content of f has the
+// CRC-32 sum, leaving f at its start.
+func verifyChecksum(sum uint32, head []byte, f *os.File) bool {
+	h := crc32.NewIEEE()
+	_, _ = h.Write(head)
+
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+
+	return h.Sum32() == sum
+}
+
+func (c *fileCache) Set(key string, val []byte, expiry time.Duration) error {
 	p := keyPath(c.path, key)
-	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+
+	if err := c.write(p, val, expiry); err != nil {
+		return err
+	}
+
+	c.evict(p)
+
+	return nil
+}
+
+func (c *fileCache) write(p string, val []byte, expiry time.Duration) error {
+	mu := c.pm.MutexAt(filepath.Base(p))
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(p), c.dirMode); err != nil {
 		return fmt.Errorf("error creating path: %w", err)
 	}
 
-	timestamp := uint64(time.Now().Add(expiry).Unix())
-	var t [8]byte
-	binary.LittleEndian.PutUint64(t[:], timestamp)
+	head, body := splitEntry(val)
+
+	coding := bodyRaw
+	if c.compress && int64(len(body)) >= c.compressMinBytes {
+		var err error
+		if body, err = compress(body); err != nil {
+			return err
+		}
+		coding = bodyCompressed
+	}
+
+	sum := crc32.Update(crc32.ChecksumIEEE(head), crc32.IEEETable, body)
+
+	meta := make([]byte, metaHeaderSize, metaHeaderSize+len(head))
+	binary.LittleEndian.PutUint64(meta[:8], uint64(c.clock.Now().Add(expiry).Unix()))
+	binary.LittleEndian.PutUint64(meta[8:16], uint64(len(body))|coding)
+	binary.LittleEndian.PutUint32(meta[16:20], sum)
+	meta = append(meta, head...)
+
+	// The body is written first so that the metadata never describes a body
+	// that is not there yet.
+	if err := writeFileAtomic(p+bodySuffix, body, c.fileMode); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p+metaSuffix, meta, c.fileMode); err != nil {
+		// The new body does not match the previous metadata, if any.
+		c.remove(p)
+		return err
+	}
+
+	c.idx.add(p, int64(len(meta)+len(body)))
 
-	return os.WriteFile(p, append(t[:], val...), 0600)
+	return nil
 }
 
-func keyHash(key string) [4]byte {
-	h := crc32.Checksum([]byte(key), crc32.IEEETable)
-	var b [4]byte
-	binary.LittleEndian.PutUint32(b[:], h)
-	return b
+// evict removes the least recently used entries, except the one at keep, until
+// the entries fit in the disk quota and maximum number of entries. The lock of
+// an entry is only taken while no other one is held.
+func (c *fileCache) evict(keep string) {
+	for c.overQuota() {
+		p, ok := c.idx.oldest(keep)
+		if !ok {
+			return
+		}
+
+		mu := c.pm.MutexAt(filepath.Base(p))
+		mu.Lock()
+		removed := c.remove(p)
+		mu.Unlock()
+
+		if removed {
+			c.evicted()
+		}
+	}
 }
 
+// overQuota reports whether the entries exceed the disk quota or the maximum
+// number of entries.
+func (c *fileCache) overQuota() bool {
+	if c.maxBytes > 0 && c.idx.bytes() > c.maxBytes {
+		return true
+	}
+
+	return c.maxEntries > 0 && c.idx.len() > c.maxEntries
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never observe a partially written entry.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), tmpFilePrefix)
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+
+	if err = f.Chmod(mode); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("error setting temporary file mode: %w", err)
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+
+	if err = os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("error renaming temporary file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *fileCache) usage() (int, int64) {
+	return c.idx.len(), c.idx.bytes()
+}
+
+func (c *fileCache) Delete(key string) error {
+	p := keyPath(c.path, key)
+
+	mu := c.pm.MutexAt(filepath.Base(p))
+	mu.Lock()
+	defer mu.Unlock()
+
+	err := os.Remove(p + metaSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing cache item: %w", err)
+	}
+
+	_ = os.Remove(p + bodySuffix)
+	c.idx.remove(p)
+
+	if err != nil {
+		return errCacheMiss
+	}
+
+	return nil
+}
+
+// Clear removes all entries under the cache path, leaving the directories in
+// place. Entries opened before they are removed can still be read to the end.
+func (c *fileCache) Clear() error {
+	return filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error clearing cache: %w", err)
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), tmpFilePrefix) {
+			return nil
+		}
+
+		switch filepath.Ext(path) {
+		case metaSuffix:
+			p := strings.TrimSuffix(path, metaSuffix)
+
+			mu := c.pm.MutexAt(filepath.Base(p))
+			mu.Lock()
+			defer mu.Unlock()
+
+			c.remove(p)
+		case bodySuffix:
+			// A body without metadata, e.g. after a crash between the writes of
+			// both files, is removed unless its entry was written meanwhile.
+			p := strings.TrimSuffix(path, bodySuffix)
+
+			mu := c.pm.MutexAt(filepath.Base(p))
+			mu.Lock()
+			defer mu.Unlock()
+
+			if _, err := os.Stat(p + metaSuffix); os.IsNotExist(err) {
+				c.remove(p)
+			}
+		}
+
+		return nil
+	})
+}
+
+// entries returns the fresh or retained responses under the cache path. Vary
+// manifests are left out, their variants are listed instead.
+func (c *fileCache) entries() ([]entryInfo, error) {
+	var entries []entryInfo
+
+	err := filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error listing cache: %w", err)
+		}
+		if info.IsDir() || filepath.Ext(path) != metaSuffix || strings.HasPrefix(info.Name(), tmpFilePrefix) {
+			return nil
+		}
+
+		if e, ok := c.entry(strings.TrimSuffix(path, metaSuffix)); ok {
+			entries = append(entries, e)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	return entries, nil
+}
+
+// entry returns the description of the entry at p, if it has not expired.
+func (c *fileCache) entry(p string) (entryInfo, bool) {
+	mu := c.pm.MutexAt(filepath.Base(p))
+	mu.RLock()
+	defer mu.RUnlock()
+
+	meta, err := ioutil.ReadFile(filepath.Clean(p + metaSuffix))
+	if err != nil || len(meta) < metaHeaderSize {
+		return entryInfo{}, false
+	}
+
+	hdr := parseMetaHeader(meta)
+	if !hdr.expires.After(c.clock.Now()) {
+		return entryInfo{}, false
+	}
+
+	data, err := unmarshalEntry(meta[metaHeaderSize:])
+	if err != nil || len(data.Vary) > 0 {
+		return entryInfo{}, false
+	}
+
+	return entryInfo{
+		Key:     data.Key,
+		Size:    int64(len(meta)) + hdr.bodySize,
+		Status:  data.Status,
+		Stored:  data.Stored,
+		Expires: data.Expires,
+	}, true
+}
+
+func keyHash(key string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(key))
+}
+
+// keyPath returns the on-disk location of the entry for key, to which the
+// suffixes of its files are appended. The key itself is never used as a file
+// name as it may contain separators or exceed name limits. Entries are sharded
+// into two levels of directories named after the first bytes of the key hash,
+// so a million entries average 15 per directory.
 func keyPath(path, key string) string {
 	h := keyHash(key)
-	key = strings.NewReplacer("/", "-", ":", "_").Replace(key)
 	return filepath.Join(
 		path,
 		hex.EncodeToString(h[0:1]),
 		hex.EncodeToString(h[1:2]),
-		hex.EncodeToString(h[2:3]),
-		hex.EncodeToString(h[3:4]),
-		key,
+		hex.EncodeToString(h[:]),
 	)
 }
 
@@ -167,18 +688,18 @@ func (m *pathMutex) MutexAt(path string) *fileLock {
 }
 
 type fileLock struct {
-	mu       sync.RWMutex
-	ref      int
-	cleanup  func()
+	mu      sync.RWMutex
+	ref     int
+	cleanup func()
 }
 
-func (l *fileLock) RLock()   { l.mu.RLock() }
-func (l *fileLock) RUnlock() { 
+func (l *fileLock) RLock() { l.mu.RLock() }
+func (l *fileLock) RUnlock() {
 	l.mu.RUnlock()
 	l.cleanup()
 }
-func (l *fileLock) Lock()   { l.mu.Lock() }
-func (l *fileLock) Unlock() { 
+func (l *fileLock) Lock() { l.mu.Lock() }
+func (l *fileLock) Unlock() {
 	l.mu.Unlock()
 	l.cleanup()
-}
\ No newline at end of file
+}
@@ -2,8 +2,20 @@ package plugin_simplecache
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -15,7 +27,7 @@ const testCacheKey = "GETlocalhost:8080/test/path"
 func TestFileCache(t *testing.T) {
 	dir := createTempDir(t)
 
-	fc, err := newFileCache(dir, time.Second)
+	fc, err := newFileCache(dir)
 	if err != nil {
 		t.Errorf("unexpected newFileCache error: %v", err)
 	}
@@ -42,6 +54,676 @@ func TestFileCache(t *testing.T) {
 	}
 }
 
+func TestNewFileCache_ReadOnly(t *testing.T) {
+	dir := createTempDir(t)
+
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chmod(dir, 0700) }()
+
+	if f, err := ioutil.TempFile(dir, "probe"); err == nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		t.Skip("file modes are not enforced for the current user")
+	}
+
+	if _, err := newFileCache(dir); err == nil {
+		t.Error("expected an error for a read-only path")
+	}
+}
+
+func TestFileCache_Modes(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+	fc.dirMode = 0750
+	fc.fileMode = 0640
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	p := keyPath(dir, testCacheKey)
+	for _, name := range []string{p + metaSuffix, p + bodySuffix} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0640 {
+			t.Errorf("unexpected mode of %s: want %o, got %o", filepath.Base(name), 0640, mode)
+		}
+	}
+
+	info, err := os.Stat(filepath.Dir(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode&^0750 != 0 {
+		t.Errorf("unexpected directory mode: want at most %o, got %o", 0750, mode)
+	}
+}
+
+func TestCache_ServeHTTPFileModes(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("some sensitive content"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 100, Cleanup: 200, FileMode: "0600", DirMode: "0700"}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	p := keyPath(dir, c.cacheKey(req))
+	for _, name := range []string{p + metaSuffix, p + bodySuffix} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("unexpected mode of %s: want %o, got %o", filepath.Base(name), 0600, mode)
+		}
+	}
+
+	info, err := os.Stat(filepath.Dir(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode&^0700 != 0 {
+		t.Errorf("unexpected directory mode: want at most %o, got %o", 0700, mode)
+	}
+}
+
+func TestFileCache_Layout(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	body := []byte{0x00, 0x1f, 0x8b, 0xff, '"'}
+	val, err := marshalEntry(&cacheData{Status: 200, Headers: http.Header{"Content-Type": {"image/png"}}, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fc.Set(testCacheKey, val, time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	p := keyPath(dir, testCacheKey)
+
+	meta, err := ioutil.ReadFile(p + metaSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(meta, []byte(`"image/png"`)) {
+		t.Errorf("expected metadata file to hold the headers, got %q", meta)
+	}
+	if bytes.Contains(meta, body) {
+		t.Errorf("expected metadata file not to hold the body, got %q", meta)
+	}
+
+	stored, err := ioutil.ReadFile(p + bodySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, body) {
+		t.Errorf("unexpected body file: want %v, got %v", body, stored)
+	}
+
+	got, err := fc.Get(testCacheKey)
+	if err != nil {
+		t.Fatalf("unexpected cache get error: %v", err)
+	}
+	if !bytes.Equal(got, val) {
+		t.Errorf("unexpected cache content: want %q, got %q", val, got)
+	}
+
+	// A body that does not match its metadata is never served.
+	if err = ioutil.WriteFile(p+bodySuffix, []byte("mismatched"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected cache get error for a mismatched body: want %v, got %v", errCacheMiss, err)
+	}
+}
+
+func TestFileCache_Corruption(t *testing.T) {
+	for _, suffix := range []string{metaSuffix, bodySuffix} {
+		t.Run(suffix, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			fc, err := newFileCache(dir)
+			if err != nil {
+				t.Fatalf("unexpected newFileCache error: %v", err)
+			}
+
+			val, err := marshalEntry(&cacheData{Status: 200, Headers: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("some body")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = fc.Set(testCacheKey, val, time.Minute); err != nil {
+				t.Fatalf("unexpected cache set error: %v", err)
+			}
+
+			p := keyPath(dir, testCacheKey)
+
+			b, err := ioutil.ReadFile(p + suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b[len(b)-1] ^= 0xff
+			if err = ioutil.WriteFile(p+suffix, b, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = fc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+				t.Errorf("unexpected cache get error for a corrupted entry: want %v, got %v", errCacheMiss, err)
+			}
+
+			for _, suffix := range []string{metaSuffix, bodySuffix} {
+				if _, err = os.Stat(p + suffix); !os.IsNotExist(err) {
+					t.Errorf("expected corrupted entry file %s to be removed, got: %v", suffix, err)
+				}
+			}
+			if n, _ := fc.usage(); n != 0 {
+				t.Errorf("unexpected indexed entries: want 0, got %d", n)
+			}
+		})
+	}
+}
+
+func TestFileCache_RemoveReplaced(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	clk := newFakeClock()
+	fc.clock = clk
+
+	p := keyPath(dir, testCacheKey)
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Second); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+	clk.Advance(time.Second)
+
+	if _, _, err = fc.openLocked(p); !errors.Is(err, errEntryExpired) {
+		t.Fatalf("unexpected open error: want %v, got %v", errEntryExpired, err)
+	}
+
+	// The entry is replaced before the expired one is removed.
+	if err = fc.Set(testCacheKey, []byte("fresh content"), time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+	fc.removeIfExpired(p)
+
+	if _, err = fc.Get(testCacheKey); err != nil {
+		t.Errorf("unexpected cache get error for a replaced expired entry: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(p + bodySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err = ioutil.WriteFile(p+bodySuffix, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, meta, err := fc.openLocked(p)
+	if !errors.Is(err, errEntryCorrupted) {
+		t.Fatalf("unexpected open error: want %v, got %v", errEntryCorrupted, err)
+	}
+
+	// The entry is replaced before the corrupted one is removed.
+	if err = fc.Set(testCacheKey, []byte("other content"), time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+	fc.removeIfCorrupted(p, meta)
+
+	got, err := fc.Get(testCacheKey)
+	if err != nil {
+		t.Fatalf("unexpected cache get error for a replaced corrupted entry: %v", err)
+	}
+	if string(got) != "other content" {
+		t.Errorf("unexpected cache content: want %q, got %q", "other content", got)
+	}
+}
+
+func TestFileCache_LegacyEntries(t *testing.T) {
+	dir := createTempDir(t)
+
+	clk := newFakeClock()
+
+	// Older versions stored every entry as a single file, named after the
+	// SHA-256 of its key in four levels of directories, starting with its expiry.
+	legacyPath := func(key string) string {
+		h := keyHash(key)
+		name := hex.EncodeToString(h[:])
+		return filepath.Join(dir, name[0:2], name[2:4], name[4:6], name[6:8], name)
+	}
+	writeFile := func(p string, b []byte) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacyEntry := func(expires time.Time) []byte {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(expires.Unix()))
+		return append(b[:], `{"Status":200}`...)
+	}
+
+	expired := legacyPath(testCacheKey)
+	writeFile(expired, legacyEntry(clk.Now().Add(-time.Second)))
+	fresh := legacyPath("other key")
+	writeFile(fresh, legacyEntry(clk.Now().Add(time.Minute)))
+
+	// Files which are not legacy entries are left alone, whatever their age.
+	h := keyHash("unrelated key")
+	name := hex.EncodeToString(h[:])
+	others := []string{
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(dir, "ab", "cd", "data"),
+		filepath.Join(dir, name[0:2], name[2:4], name),
+		filepath.Join(dir, "00", "00", "00", "00", name),
+	}
+	for _, p := range others {
+		writeFile(p, bytes.Repeat([]byte{0}, 16))
+	}
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+	fc.clock = clk
+
+	if n, _ := fc.usage(); n != 0 {
+		t.Errorf("unexpected indexed entries: want 0, got %d", n)
+	}
+	if _, err = fc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected cache get error: want %v, got %v", errCacheMiss, err)
+	}
+
+	fc.removeExpired()
+
+	if _, err = os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expected expired legacy entry to be removed, got: %v", err)
+	}
+	for _, p := range append(others, fresh) {
+		if _, err = os.Stat(p); err != nil {
+			t.Errorf("expected %s to be kept, got: %v", p, err)
+		}
+	}
+}
+
+func TestFileCache_ConcurrentClear(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		if err = fc.Set(keys[i], []byte("some content"), time.Minute); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(keys))
+
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+
+			if _, err := fc.Get(key); err != nil && !errors.Is(err, errCacheMiss) {
+				errs <- fmt.Errorf("unexpected cache get error for %q during clear: %w", key, err)
+			}
+		}(key)
+	}
+
+	if err = fc.Clear(); err != nil {
+		t.Errorf("unexpected clear error: %v", err)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := countEntries(t, dir); n != 0 {
+		t.Errorf("unexpected cache entries after clear: want 0, got %d", n)
+	}
+	if n, size := fc.usage(); n != 0 || size != 0 {
+		t.Errorf("unexpected usage after clear: %d entries, %d bytes", n, size)
+	}
+	if _, err = os.Stat(dir); err != nil {
+		t.Errorf("expected cache directory to be kept: %v", err)
+	}
+}
+
+func TestFileCache_ClearOrphanedBody(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	// A crash between the writes of both files leaves a body without metadata.
+	p := keyPath(dir, testCacheKey)
+	if err = os.Remove(p + metaSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := filepath.Join(dir, statsFileName)
+	if err = ioutil.WriteFile(stats, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fc.Clear(); err != nil {
+		t.Fatalf("unexpected clear error: %v", err)
+	}
+
+	if _, err = os.Stat(p + bodySuffix); !os.IsNotExist(err) {
+		t.Errorf("expected orphaned body to be removed, got: %v", err)
+	}
+	if _, err = os.Stat(stats); err != nil {
+		t.Errorf("expected persisted statistics to be kept, got: %v", err)
+	}
+}
+
+func TestFileCache_Compress(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	content := []byte(`{"Body":"` + strings.Repeat("compressible ", 1024) + `"}`)
+
+	// Entries written before compression was enabled must stay readable.
+	if err = fc.Set("uncompressed", content, time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	fc.compress = true
+
+	if err = fc.Set(testCacheKey, content, time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	for _, key := range []string{"uncompressed", testCacheKey} {
+		got, err := fc.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected cache get error for %q: %v", key, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("unexpected cache content for %q", key)
+		}
+	}
+
+	info, err := os.Stat(keyPath(dir, testCacheKey) + bodySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= int64(len(content)) {
+		t.Errorf("expected compressed file to be smaller than %d bytes, got %d", len(content), info.Size())
+	}
+}
+
+func TestFileCache_CompressMinBytes(t *testing.T) {
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, _ = zw.Write([]byte("an already compressed body"))
+	_ = zw.Close()
+
+	tests := []struct {
+		name           string
+		body           []byte
+		wantCompressed bool
+	}{
+		{
+			name: "should store a small body raw",
+			body: []byte("small"),
+		},
+		{
+			name: "should store a small compressed body raw",
+			body: gzipped.Bytes(),
+		},
+		{
+			name:           "should compress a large body",
+			body:           bytes.Repeat([]byte("compressible "), 1024),
+			wantCompressed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			fc, err := newFileCache(dir)
+			if err != nil {
+				t.Fatalf("unexpected newFileCache error: %v", err)
+			}
+			fc.compress = true
+			fc.compressMinBytes = 1024
+
+			content, err := marshalEntry(&cacheData{Status: http.StatusOK, Body: test.body})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = fc.Set(testCacheKey, content, time.Minute); err != nil {
+				t.Fatalf("unexpected cache set error: %v", err)
+			}
+
+			got, err := fc.Get(testCacheKey)
+			if err != nil {
+				t.Fatalf("unexpected cache get error: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Error("unexpected cache content")
+			}
+
+			stored, err := ioutil.ReadFile(keyPath(dir, testCacheKey) + bodySuffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw := bytes.Equal(stored, test.body); raw == test.wantCompressed {
+				t.Errorf("unexpected stored body: want compressed %t, got %d bytes for %d", test.wantCompressed, len(stored), len(test.body))
+			}
+		})
+	}
+}
+
+func TestFileCache_MaxBytes(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	// Every entry takes 120 bytes on disk, so three of them fit.
+	fc.maxBytes = 370
+	content := bytes.Repeat([]byte("a"), 100)
+
+	for _, key := range []string{"key0", "key1", "key2"} {
+		if err = fc.Set(key, content, time.Minute); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	if _, err = fc.Get("key0"); err != nil {
+		t.Fatalf("unexpected cache get error: %v", err)
+	}
+
+	if err = fc.Set("key3", content, time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	for key, want := range map[string]error{"key0": nil, "key1": errCacheMiss, "key2": nil, "key3": nil} {
+		if _, err = fc.Get(key); !errors.Is(err, want) {
+			t.Errorf("unexpected cache get error for %q: want %v, got %v", key, want, err)
+		}
+	}
+
+	if n := countEntries(t, dir); n != 3 {
+		t.Errorf("unexpected cache entries: want 3, got %d", n)
+	}
+	if size := fc.idx.bytes(); size > fc.maxBytes {
+		t.Errorf("unexpected cache size: want at most %d, got %d", fc.maxBytes, size)
+	}
+	if n := fc.Evictions(); n != 1 {
+		t.Errorf("unexpected evictions: want 1, got %d", n)
+	}
+
+	// A restarted cache picks up the existing entries.
+	if size := loadFileIndex(dir).bytes(); size != fc.idx.bytes() {
+		t.Errorf("unexpected loaded index size: want %d, got %d", fc.idx.bytes(), size)
+	}
+}
+
+func TestFileCache_MaxEntries(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+	fc.maxEntries = 3
+
+	for i := 0; i < 10; i++ {
+		if err = fc.Set(fmt.Sprintf("key%d", i), []byte("content"), time.Minute); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+		if n := countEntries(t, dir); n > fc.maxEntries {
+			t.Fatalf("unexpected cache entries after %d sets: want at most %d, got %d", i+1, fc.maxEntries, n)
+		}
+	}
+
+	if n := countEntries(t, dir); n != 3 {
+		t.Errorf("unexpected cache entries: want 3, got %d", n)
+	}
+	for key, want := range map[string]error{"key6": errCacheMiss, "key7": nil, "key8": nil, "key9": nil} {
+		if _, err = fc.Get(key); !errors.Is(err, want) {
+			t.Errorf("unexpected cache get error for %q: want %v, got %v", key, want, err)
+		}
+	}
+	if n := fc.Evictions(); n != 7 {
+		t.Errorf("unexpected evictions: want 7, got %d", n)
+	}
+}
+
+func TestFileCache_Expiry(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	clk := newFakeClock()
+	fc.clock = clk
+
+	if err = fc.Set(testCacheKey, []byte("some content"), 10*time.Second); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	clk.Advance(9 * time.Second)
+
+	if _, err = fc.Get(testCacheKey); err != nil {
+		t.Errorf("unexpected cache get error before expiry: %v", err)
+	}
+
+	clk.Advance(time.Second)
+
+	if _, err = fc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected cache get error after expiry: want %v, got %v", errCacheMiss, err)
+	}
+
+	if _, err = os.Stat(keyPath(dir, testCacheKey) + metaSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected expired cache file to be removed, got: %v", err)
+	}
+}
+
+func TestFileCache_RunCleanup(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Second); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	fc.clock = &fakeClock{now: time.Now().Add(time.Minute)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		runCleanup(ctx, fc, 10*time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err = os.Stat(keyPath(dir, testCacheKey) + metaSuffix); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected expired cache file to be removed by cleanup")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected cleanup to stop once the context is cancelled")
+	}
+}
+
 func TestFileCache_ConcurrentAccess(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -54,7 +736,7 @@ func TestFileCache_ConcurrentAccess(t *testing.T) {
 
 	dir := createTempDir(t)
 
-	fc, err := newFileCache(dir, time.Second)
+	fc, err := newFileCache(dir)
 	if err != nil {
 		t.Errorf("unexpected newFileCache error: %v", err)
 	}
@@ -102,6 +784,113 @@ func TestFileCache_ConcurrentAccess(t *testing.T) {
 	wg.Wait()
 }
 
+func TestFileCache_ConcurrentSet(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 64)
+
+	for i := 0; i < 32; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			b, _ := json.Marshal(cacheData{Status: 200, Body: bytes.Repeat([]byte("a"), i*1024)})
+			if err := fc.Set(testCacheKey, b, time.Minute); err != nil {
+				errs <- fmt.Errorf("unexpected cache set error: %w", err)
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			b, err := fc.Get(testCacheKey)
+			if err != nil {
+				return
+			}
+			if err = json.Unmarshal(b, &cacheData{}); err != nil {
+				errs <- fmt.Errorf("unexpected partial cache content: %w", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	b, err := fc.Get(testCacheKey)
+	if err != nil {
+		t.Fatalf("unexpected cache get error: %v", err)
+	}
+	if err = json.Unmarshal(b, &cacheData{}); err != nil {
+		t.Errorf("unexpected final cache content: %v", err)
+	}
+
+	if n := countEntries(t, dir); n != 1 {
+		t.Errorf("unexpected cache entries: want 1, got %d", n)
+	}
+}
+
+func TestKeyPath(t *testing.T) {
+	dir := createTempDir(t)
+
+	keys := []string{
+		"GETlocalhost/a?|Authorization:",
+		"GETlocalhost/a/b?|Authorization:",
+		"GETlocalhost/a/b/c?|Authorization:",
+		"GETlocalhost/a/b/c?q=" + strings.Repeat("x", 1024) + "|Authorization:",
+	}
+
+	seen := make(map[string]string)
+
+	for _, key := range keys {
+		p := keyPath(dir, key)
+
+		if other, ok := seen[p]; ok {
+			t.Errorf("keys %q and %q map to the same path %q", key, other, p)
+		}
+		seen[p] = key
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := keyHash(key)
+		name := hex.EncodeToString(h[:])
+		if want := filepath.Join(name[0:2], name[2:4], name); rel != want {
+			t.Errorf("unexpected path for %q: want %s, got %s", key, want, rel)
+		}
+		if len(name) != 2*sha256.Size {
+			t.Errorf("unexpected file name for %q: %q", key, name)
+		}
+	}
+
+	fc, err := newFileCache(dir)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if err = fc.Set(keys[0], []byte("content"), time.Minute); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+	if _, err = os.Stat(keyPath(dir, keys[0]) + metaSuffix); err != nil {
+		t.Errorf("expected the entry at its sharded path: %v", err)
+	}
+	if got, err := fc.Get(keys[0]); err != nil || string(got) != "content" {
+		t.Errorf("unexpected cache get result: %q, %v", got, err)
+	}
+}
+
 func TestPathMutex(t *testing.T) {
 	pm := &pathMutex{lock: map[string]*fileLock{}}
 
@@ -142,7 +931,7 @@ func TestPathMutex(t *testing.T) {
 func BenchmarkFileCache_Get(b *testing.B) {
 	dir := createTempDir(b)
 
-	fc, err := newFileCache(dir, time.Minute)
+	fc, err := newFileCache(dir)
 	if err != nil {
 		b.Errorf("unexpected newFileCache error: %v", err)
 	}
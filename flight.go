@@ -0,0 +1,69 @@
+package plugin_simplecache
+
+import "sync"
+
+// flightGroup coalesces concurrent calls sharing a key into a single execution.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*sync.WaitGroup
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*sync.WaitGroup)}
+}
+
+// Do executes fn unless a call for key is already in flight, in which case it
+// waits for that call to complete. It reports whether fn was executed.
+func (g *flightGroup) Do(key string, fn func()) bool {
+	g.mu.Lock()
+	if wg, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		wg.Wait()
+		return false
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	g.calls[key] = wg
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		wg.Done()
+	}()
+
+	fn()
+
+	return true
+}
+
+// TryGo starts fn in a goroutine as the call for key, unless one is already in
+// flight. Calls of Do for key wait for fn like for any other call. It reports
+// whether fn was started.
+func (g *flightGroup) TryGo(key string, fn func()) bool {
+	g.mu.Lock()
+	if _, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		return false
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	g.calls[key] = wg
+	g.mu.Unlock()
+
+	go func() {
+		defer func() {
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			wg.Done()
+		}()
+
+		fn()
+	}()
+
+	return true
+}
@@ -0,0 +1,126 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+	g := newFlightGroup()
+
+	var (
+		wg      sync.WaitGroup
+		calls   int32
+		fetched int32
+	)
+
+	release := make(chan struct{})
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ok := g.Do("key", func() {
+				atomic.AddInt32(&calls, 1)
+				<-release
+			})
+			if ok {
+				atomic.AddInt32(&fetched, 1)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("unexpected calls: want 1, got %d", calls)
+	}
+	if fetched != 1 {
+		t.Errorf("unexpected executing callers: want 1, got %d", fetched)
+	}
+	if l := len(g.calls); l > 0 {
+		t.Errorf("unexpected in flight calls: want 0, got %d", l)
+	}
+}
+
+func TestFlightGroup_TryGo(t *testing.T) {
+	g := newFlightGroup()
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	if !g.TryGo("key", func() { <-release; close(done) }) {
+		t.Fatal("expected the first call to start")
+	}
+	if g.TryGo("key", func() { t.Error("unexpected second call") }) {
+		t.Error("expected a call in flight to prevent another")
+	}
+
+	waited := make(chan bool)
+	go func() { waited <- g.Do("key", func() { t.Error("unexpected waiting call") }) }()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-done
+
+	if <-waited {
+		t.Error("expected Do to wait for the started call")
+	}
+	if !g.TryGo("key", func() {}) {
+		t.Error("expected a call to start once the previous one completed")
+	}
+}
+
+func TestCache_ServeHTTPConcurrentMisses(t *testing.T) {
+	dir := createTempDir(t)
+
+	var calls int32
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("origin"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	recorders := make([]*httptest.ResponseRecorder, 10)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+
+		go func(rw *httptest.ResponseRecorder) {
+			defer wg.Done()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+		}(recorders[i])
+	}
+
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("unexpected next handler calls: want 1, got %d", calls)
+	}
+
+	for _, rw := range recorders {
+		if body := rw.Body.String(); body != "origin" {
+			t.Errorf("unexpected body: want %q, got %q", "origin", body)
+		}
+	}
+}
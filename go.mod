@@ -1,8 +1,15 @@
 module github.com/traefik/plugin-simplecache
 
-go 1.15
+go 1.21
 
 require (
+	github.com/alicebob/miniredis/v2 v2.33.0
+	github.com/andybalholm/brotli v1.1.0
 	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35
+)
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/stretchr/testify v1.6.1 // indirect
-)
\ No newline at end of file
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+)
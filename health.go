@@ -0,0 +1,55 @@
+package plugin_simplecache
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	healthOK    = "ok"
+	healthError = "error"
+)
+
+// healthInfo describes the state of the cache backend.
+type healthInfo struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// Health checks that the backend is usable and reports its usage. The usage
+// comes from the counters of the backend, so it is cheap to call.
+func (m *cache) Health() healthInfo {
+	info := healthInfo{Status: healthOK}
+
+	if s, ok := m.cache.(sizer); ok {
+		info.Entries, info.Bytes = s.usage()
+	}
+
+	if c, ok := m.cache.(checker); ok {
+		if err := c.check(); err != nil {
+			info.Status = healthError
+			info.Error = err.Error()
+		}
+	}
+
+	return info
+}
+
+// serveHealth writes the health of the cache as JSON, with a 503 when the
+// backend is not usable.
+func (m *cache) serveHealth(w http.ResponseWriter) {
+	info := m.Health()
+
+	code := http.StatusOK
+	if info.Status != healthOK {
+		m.logger.Warn("Cache backend is unhealthy", "error", info.Error)
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,88 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCache_ServeHTTPHealth(t *testing.T) {
+	tests := []struct {
+		name        string
+		removeDir   bool
+		wantCode    int
+		wantStatus  string
+		wantEntries int
+	}{
+		{
+			name:        "should report a healthy cache",
+			wantCode:    http.StatusOK,
+			wantStatus:  healthOK,
+			wantEntries: 1,
+		},
+		{
+			name:        "should report a cache path that is not writable",
+			removeDir:   true,
+			wantCode:    http.StatusServiceUnavailable,
+			wantStatus:  healthError,
+			wantEntries: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				_, _ = rw.Write([]byte("content"))
+			}
+
+			dir := createTempDir(t)
+			cfg := &Config{Path: dir, MaxExpiry: 100, Cleanup: 200, HealthPath: "/_cache/health"}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if test.removeDir {
+				if err = os.RemoveAll(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/_cache/health", nil))
+
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+			}
+			if calls != 1 {
+				t.Errorf("unexpected next handler calls: want 1, got %d", calls)
+			}
+
+			var info map[string]interface{}
+			if err = json.Unmarshal(rw.Body.Bytes(), &info); err != nil {
+				t.Fatal(err)
+			}
+			if info["status"] != test.wantStatus {
+				t.Errorf("unexpected health status: want %q, got %v", test.wantStatus, info["status"])
+			}
+			if info["entries"] != float64(test.wantEntries) {
+				t.Errorf("unexpected entries: want %d, got %v", test.wantEntries, info["entries"])
+			}
+			if bytes, ok := info["bytes"].(float64); !ok || bytes <= 0 {
+				t.Errorf("unexpected bytes: %v", info["bytes"])
+			}
+			if _, ok := info["error"]; ok != test.removeDir {
+				t.Errorf("unexpected error field: %v", info["error"])
+			}
+		})
+	}
+}
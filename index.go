@@ -0,0 +1,138 @@
+package plugin_simplecache
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type indexEntry struct {
+	path string
+	size int64
+}
+
+// fileIndex tracks the size and recency of use of the file cache entries, so
+// the least recently used ones can be evicted.
+type fileIndex struct {
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// loadFileIndex indexes the entries found under path, counting both files of
+// every entry. Entries are ordered by the modification time of their metadata,
+// as access times are not persisted.
+func loadFileIndex(path string) *fileIndex {
+	type file struct {
+		indexEntry
+		modTime int64
+	}
+
+	byPath := make(map[string]*file)
+	_ = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), tmpFilePrefix) {
+			return nil
+		}
+
+		ext := filepath.Ext(p)
+		if ext != metaSuffix && ext != bodySuffix {
+			return nil
+		}
+
+		p = strings.TrimSuffix(p, ext)
+		f, ok := byPath[p]
+		if !ok {
+			f = &file{indexEntry: indexEntry{path: p}}
+			byPath[p] = f
+		}
+		f.size += info.Size()
+		if ext == metaSuffix {
+			f.modTime = info.ModTime().UnixNano()
+		}
+		return nil
+	})
+
+	files := make([]*file, 0, len(byPath))
+	for _, f := range byPath {
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime < files[j].modTime })
+
+	idx := &fileIndex{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	for _, f := range files {
+		idx.add(f.path, f.size)
+	}
+
+	return idx
+}
+
+// add records the entry at path as the most recently used one.
+func (i *fileIndex) add(path string, size int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if el, ok := i.entries[path]; ok {
+		i.size -= i.lru.Remove(el).(*indexEntry).size
+	}
+
+	i.entries[path] = i.lru.PushFront(&indexEntry{path: path, size: size})
+	i.size += size
+}
+
+// touch marks the entry at path as the most recently used one.
+func (i *fileIndex) touch(path string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if el, ok := i.entries[path]; ok {
+		i.lru.MoveToFront(el)
+	}
+}
+
+func (i *fileIndex) remove(path string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if el, ok := i.entries[path]; ok {
+		i.size -= i.lru.Remove(el).(*indexEntry).size
+		delete(i.entries, path)
+	}
+}
+
+// oldest returns the path of the least recently used entry other than keep.
+func (i *fileIndex) oldest(keep string) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for el := i.lru.Back(); el != nil; el = el.Prev() {
+		if p := el.Value.(*indexEntry).path; p != keep {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+// bytes returns the total size of the indexed entries.
+func (i *fileIndex) bytes() int64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.size
+}
+
+// len returns the number of indexed entries.
+func (i *fileIndex) len() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return len(i.entries)
+}
@@ -0,0 +1,332 @@
+package plugin_simplecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// keyData is the data a key template is executed with.
+type keyData struct {
+	Method string
+	Scheme string
+	Host   string
+	Path   string
+	Query  string
+	Header http.Header
+
+	// BodyHash is the hex SHA-256 of the body of POST requests cached with
+	// cachePostBody, and empty otherwise.
+	BodyHash string
+
+	// Tenant is the value of the tenant header, if configured.
+	Tenant string
+}
+
+// parseKeyTemplate compiles the configured key template, returning nil when
+// none is configured.
+func parseKeyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("key").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyTemplate: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// keyHeaders returns the sorted, canonical and unique names of the request
+// headers that contribute to the cache key.
+func keyHeaders(names []string) []string {
+	seen := make(map[string]struct{}, len(names))
+
+	headers := make([]string, 0, len(names))
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if _, ok := seen[name]; ok || name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+		headers = append(headers, name)
+	}
+
+	sort.Strings(headers)
+
+	return headers
+}
+
+// keyCookies returns the sorted and unique names of the request cookies that
+// contribute to the cache key. Cookie names are case sensitive.
+func keyCookies(names []string) []string {
+	seen := make(map[string]struct{}, len(names))
+
+	cookies := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if _, ok := seen[name]; ok || name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+		cookies = append(cookies, name)
+	}
+
+	sort.Strings(cookies)
+
+	return cookies
+}
+
+// ignoredParams returns the set of query parameter names left out of the cache key.
+func ignoredParams(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+
+	return set
+}
+
+// keyPrefix returns the namespace prepended to the cache keys of the middleware
+// called name, which keeps instances sharing a backend apart.
+func keyPrefix(cfg *Config, name string) string {
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = name
+	}
+	if prefix == "" {
+		return ""
+	}
+
+	return prefix + ":"
+}
+
+// hashedKeySuffix marks a key shortened to its maximum length, it is followed by
+// the hex encoded SHA-256 of the whole key.
+const hashedKeySuffix = "|sha256:"
+
+// minKeyLength is the smallest maximum key length, which leaves room for part
+// of the key before its hash.
+const minKeyLength = 128
+
+// cacheKey returns the key the response to r is stored under, in the namespace
+// of the middleware.
+func (m *cache) cacheKey(r *http.Request) string {
+	key := m.keyPrefix + m.requestKey(r)
+
+	if limit := m.cfg.MaxKeyLength; limit > 0 && len(key) > limit {
+		m.logger.Debug("Cache key exceeds the maximum length, hashing it", "path", r.URL.Path, "length", len(key))
+		return boundedKey(key, limit)
+	}
+
+	return key
+}
+
+// boundedKey shortens key to limit bytes. The start of the key is kept, so the
+// key still reads as the request it stands for, and the rest is replaced by
+// the hash of the whole key, which keeps distinct keys apart.
+func boundedKey(key string, limit int) string {
+	sum := sha256.Sum256([]byte(key))
+
+	// The key is cut on a character boundary.
+	n := limit - len(hashedKeySuffix) - 2*sha256.Size
+	for n > 0 && !utf8.RuneStart(key[n]) {
+		n--
+	}
+
+	return key[:n] + hashedKeySuffix + hex.EncodeToString(sum[:])
+}
+
+// requestKey returns the key of r within the namespace of the middleware.
+func (m *cache) requestKey(r *http.Request) string {
+	// HEAD is answered from the GET entry of the same resource.
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+
+	if m.keyTmpl != nil {
+		var b strings.Builder
+		err := m.keyTmpl.Execute(&b, keyData{
+			Method:   method,
+			Scheme:   requestScheme(r),
+			Host:     m.keyHost(r),
+			Path:     m.keyPath(r.URL.Path),
+			Query:    m.keyQuery(r.URL.RawQuery),
+			Header:   r.Header,
+			BodyHash: bodyHash(r),
+			Tenant:   m.tenant(r),
+		})
+		if err == nil {
+			return b.String()
+		}
+		m.logger.Error("Error executing key template", "error", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(m.pathKey(method, r))
+	b.WriteString("?")
+	b.WriteString(m.keyQuery(r.URL.RawQuery))
+
+	if hash := bodyHash(r); hash != "" {
+		b.WriteString("|body:")
+		b.WriteString(hash)
+	}
+
+	// The tenant replaces the credentials of its users in the key.
+	tenant := m.tenant(r)
+	if tenant != "" {
+		b.WriteString("|tenant:")
+		b.WriteString(tenant)
+	}
+
+	// Shared authorized responses are told apart by their Cache-Control once
+	// stored, see perCredentials.
+	for _, name := range m.headers {
+		if (tenant != "" || m.cfg.ShareAuthorized) && name == "Authorization" {
+			continue
+		}
+
+		vals := append([]string(nil), r.Header.Values(name)...)
+		sort.Strings(vals)
+
+		b.WriteString("|")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(vals, ","))
+	}
+
+	// Only the named cookies are part of the key, the others are ignored.
+	for _, name := range m.cookies {
+		b.WriteString("|cookie:")
+		b.WriteString(name)
+		b.WriteString("=")
+		if c, err := r.Cookie(name); err == nil {
+			b.WriteString(c.Value)
+		}
+	}
+
+	return b.String()
+}
+
+// requestScheme returns the scheme of r, as forwarded by a proxy in front of
+// Traefik if any.
+func requestScheme(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// keyHost returns the host of r as part of the cache key. The Host header is
+// used as sent unless the port is included, in which case the default port of
+// the scheme is added when the header has none.
+func (m *cache) keyHost(r *http.Request) string {
+	if !m.cfg.IncludePort {
+		return r.Host
+	}
+
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		port = "80"
+		if requestScheme(r) == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(strings.ToLower(host), port)
+}
+
+// tenant returns the value of the tenant header of r, or an empty string when
+// none is configured or sent.
+func (m *cache) tenant(r *http.Request) string {
+	if m.cfg.TenantHeader == "" {
+		return ""
+	}
+
+	return r.Header.Get(m.cfg.TenantHeader)
+}
+
+// pathKey returns the default key of r for method up to its path, which the
+// keys of all the requests for the same path start with.
+func (m *cache) pathKey(method string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(method)
+	if m.cfg.IncludeScheme {
+		b.WriteString(requestScheme(r))
+		b.WriteString("://")
+	}
+	b.WriteString(m.keyHost(r))
+	b.WriteString(m.keyPath(r.URL.Path))
+
+	return b.String()
+}
+
+// keyPath returns the request path as part of the cache key, without its
+// trailing slashes and in lower case when enabled.
+func (m *cache) keyPath(path string) string {
+	if m.cfg.NormalizeTrailingSlash {
+		if path = strings.TrimRight(path, "/"); path == "" {
+			path = "/"
+		}
+	}
+	if m.cfg.LowercasePath {
+		path = strings.ToLower(path)
+	}
+
+	return path
+}
+
+// keyQuery returns the raw query without ignored parameters, sorted by
+// parameter name when enabled. The order of repeated parameters is kept.
+func (m *cache) keyQuery(rawQuery string) string {
+	if len(m.ignored) == 0 && !m.cfg.SortQueryParams {
+		return rawQuery
+	}
+
+	type param struct{ name, pair string }
+
+	var params []param
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+
+		name := pair
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name = pair[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if _, ok := m.ignored[name]; ok {
+			continue
+		}
+		params = append(params, param{name: name, pair: pair})
+	}
+
+	if m.cfg.SortQueryParams {
+		sort.SliceStable(params, func(i, j int) bool { return params[i].name < params[j].name })
+	}
+
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p.pair
+	}
+
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,569 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestKeyHeaders(t *testing.T) {
+	got := keyHeaders([]string{"x-tenant", "Authorization", " X-Tenant ", ""})
+
+	want := []string{"Authorization", "X-Tenant"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected headers: want %v, got %v", want, got)
+	}
+}
+
+func TestCache_CacheKey(t *testing.T) {
+	newRequest := func(headers map[string][]string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path?a=1", nil)
+		for name, vals := range headers {
+			for _, val := range vals {
+				req.Header.Add(name, val)
+			}
+		}
+		return req
+	}
+
+	tests := []struct {
+		name      string
+		headers   []string
+		a, b      map[string][]string
+		wantEqual bool
+	}{
+		{
+			name:      "should share keys without headers",
+			a:         map[string][]string{"Authorization": {"token-a"}},
+			b:         map[string][]string{"Authorization": {"token-b"}},
+			wantEqual: true,
+		},
+		{
+			name:      "should split keys on a configured header",
+			headers:   []string{"authorization"},
+			a:         map[string][]string{"Authorization": {"token-a"}},
+			b:         map[string][]string{"Authorization": {"token-b"}},
+			wantEqual: false,
+		},
+		{
+			name:      "should ignore headers that are not configured",
+			headers:   []string{"Authorization"},
+			a:         map[string][]string{"Authorization": {"token"}, "X-Tenant": {"a"}},
+			b:         map[string][]string{"Authorization": {"token"}, "X-Tenant": {"b"}},
+			wantEqual: true,
+		},
+		{
+			name:      "should split keys on any of multiple headers",
+			headers:   []string{"X-Tenant", "Authorization"},
+			a:         map[string][]string{"Authorization": {"token"}, "X-Tenant": {"a"}},
+			b:         map[string][]string{"Authorization": {"token"}, "X-Tenant": {"b"}},
+			wantEqual: false,
+		},
+		{
+			name:      "should not depend on value order",
+			headers:   []string{"X-Tenant"},
+			a:         map[string][]string{"X-Tenant": {"a", "b"}},
+			b:         map[string][]string{"X-Tenant": {"b", "a"}},
+			wantEqual: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &cache{cfg: &Config{}, headers: keyHeaders(test.headers)}
+
+			a := c.cacheKey(newRequest(test.a))
+			b := c.cacheKey(newRequest(test.b))
+
+			if (a == b) != test.wantEqual {
+				t.Errorf("unexpected key equality: want %t, got %q and %q", test.wantEqual, a, b)
+			}
+		})
+	}
+}
+
+func TestCache_CacheKeyQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		ignore    []string
+		sort      bool
+		a, b      string
+		wantEqual bool
+	}{
+		{
+			name:      "should keep the raw query by default",
+			a:         "a=1&b=2",
+			b:         "b=2&a=1",
+			wantEqual: false,
+		},
+		{
+			name:      "should ignore tracking parameters",
+			ignore:    []string{"utm_source", "fbclid", "gclid"},
+			a:         "a=1&utm_source=mail&gclid=abc",
+			b:         "fbclid=def&a=1",
+			wantEqual: true,
+		},
+		{
+			name:      "should keep parameters that are not ignored",
+			ignore:    []string{"utm_source"},
+			a:         "a=1&utm_source=mail",
+			b:         "a=2&utm_source=mail",
+			wantEqual: false,
+		},
+		{
+			name:      "should normalize parameter order",
+			sort:      true,
+			a:         "a=1&b=2",
+			b:         "b=2&a=1",
+			wantEqual: true,
+		},
+		{
+			name:      "should keep the order of repeated parameters",
+			sort:      true,
+			a:         "a=1&a=2",
+			b:         "a=2&a=1",
+			wantEqual: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &cache{cfg: &Config{SortQueryParams: test.sort}, ignored: ignoredParams(test.ignore)}
+
+			reqA := httptest.NewRequest(http.MethodGet, "http://localhost/some/path?"+test.a, nil)
+			reqB := httptest.NewRequest(http.MethodGet, "http://localhost/some/path?"+test.b, nil)
+
+			a := c.cacheKey(reqA)
+			b := c.cacheKey(reqB)
+
+			if (a == b) != test.wantEqual {
+				t.Errorf("unexpected key equality: want %t, got %q and %q", test.wantEqual, a, b)
+			}
+			if reqA.URL.RawQuery != test.a {
+				t.Errorf("unexpected request mutation: want %q, got %q", test.a, reqA.URL.RawQuery)
+			}
+		})
+	}
+}
+
+func TestCache_CacheKeyPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		a, b      string
+		wantEqual bool
+	}{
+		{
+			name:      "should keep trailing slashes by default",
+			a:         "/products/",
+			b:         "/products",
+			wantEqual: false,
+		},
+		{
+			name:      "should keep the path case by default",
+			a:         "/Products",
+			b:         "/products",
+			wantEqual: false,
+		},
+		{
+			name:      "should collapse trailing slashes",
+			cfg:       Config{NormalizeTrailingSlash: true},
+			a:         "/products//",
+			b:         "/products",
+			wantEqual: true,
+		},
+		{
+			name:      "should keep the root path",
+			cfg:       Config{NormalizeTrailingSlash: true},
+			a:         "//",
+			b:         "/",
+			wantEqual: true,
+		},
+		{
+			name:      "should lowercase the path",
+			cfg:       Config{LowercasePath: true},
+			a:         "/Products/Shoes",
+			b:         "/products/shoes",
+			wantEqual: true,
+		},
+		{
+			name:      "should not lowercase the query",
+			cfg:       Config{LowercasePath: true, NormalizeTrailingSlash: true},
+			a:         "/Products/?q=Shoes",
+			b:         "/products?q=shoes",
+			wantEqual: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := test.cfg
+			c := &cache{cfg: &cfg}
+
+			a := c.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.a, nil))
+			b := c.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.b, nil))
+
+			if (a == b) != test.wantEqual {
+				t.Errorf("unexpected key equality: want %t, got %q and %q", test.wantEqual, a, b)
+			}
+		})
+	}
+}
+
+func TestCache_CacheKeyHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		a, b      string
+		proto     string
+		wantEqual bool
+	}{
+		{
+			name:      "should ignore the scheme by default",
+			a:         "http://localhost/",
+			b:         "https://localhost/",
+			wantEqual: true,
+		},
+		{
+			name:      "should keep the port as sent by default",
+			a:         "http://localhost:80/",
+			b:         "http://localhost/",
+			wantEqual: false,
+		},
+		{
+			name:      "should include the scheme",
+			cfg:       Config{IncludeScheme: true},
+			a:         "http://localhost/",
+			b:         "https://localhost/",
+			wantEqual: false,
+		},
+		{
+			name:      "should use the forwarded scheme",
+			cfg:       Config{IncludeScheme: true},
+			a:         "http://localhost/",
+			b:         "https://localhost/",
+			proto:     "https",
+			wantEqual: true,
+		},
+		{
+			name:      "should add the default port",
+			cfg:       Config{IncludePort: true},
+			a:         "http://localhost:80/",
+			b:         "http://localhost/",
+			wantEqual: true,
+		},
+		{
+			name:      "should add the default port of https",
+			cfg:       Config{IncludePort: true},
+			a:         "https://localhost:443/",
+			b:         "https://localhost/",
+			wantEqual: true,
+		},
+		{
+			name:      "should keep other ports",
+			cfg:       Config{IncludePort: true},
+			a:         "http://localhost:8080/",
+			b:         "http://localhost/",
+			wantEqual: false,
+		},
+		{
+			name:      "should add the default port to IPv6 hosts",
+			cfg:       Config{IncludePort: true},
+			a:         "http://[::1]:80/",
+			b:         "http://[::1]/",
+			wantEqual: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := test.cfg
+			c := &cache{cfg: &cfg}
+
+			reqA := httptest.NewRequest(http.MethodGet, test.a, nil)
+			reqB := httptest.NewRequest(http.MethodGet, test.b, nil)
+			if test.proto != "" {
+				reqA.Header.Set("X-Forwarded-Proto", test.proto)
+				reqB.Header.Set("X-Forwarded-Proto", test.proto)
+			}
+
+			a, b := c.cacheKey(reqA), c.cacheKey(reqB)
+
+			if (a == b) != test.wantEqual {
+				t.Errorf("unexpected key equality: want %t, got %q and %q", test.wantEqual, a, b)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPTenantHeader(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "public, max-age=20")
+		_, _ = rw.Write([]byte(req.Header.Get("X-Tenant-ID")))
+	}
+
+	cfg := &Config{
+		MaxExpiry:     100,
+		Cleanup:       200,
+		Backend:       memoryBackend,
+		VaryByHeaders: []string{"Authorization"},
+		TenantHeader:  "X-Tenant-ID",
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		tenant, auth string
+		wantCalls    int
+	}{
+		{tenant: "a", auth: "token-1", wantCalls: 1},
+		{tenant: "a", auth: "token-2", wantCalls: 1},
+		{tenant: "b", auth: "token-1", wantCalls: 2},
+		{tenant: "", auth: "token-1", wantCalls: 3},
+		{tenant: "", auth: "token-2", wantCalls: 4},
+	}
+
+	for _, test := range requests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("Authorization", test.auth)
+		if test.tenant != "" {
+			req.Header.Set("X-Tenant-ID", test.tenant)
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if calls != test.wantCalls {
+			t.Errorf("unexpected next handler calls for tenant %q with %q: want %d, got %d", test.tenant, test.auth, test.wantCalls, calls)
+		}
+		if rw.Body.String() != test.tenant {
+			t.Errorf("unexpected body for tenant %q: got %q", test.tenant, rw.Body.String())
+		}
+	}
+}
+
+func TestCache_ServeHTTPVaryByCookies(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "public, max-age=20")
+		locale := "en"
+		if c, err := req.Cookie("locale"); err == nil {
+			locale = c.Value
+		}
+		_, _ = rw.Write([]byte(locale))
+	}
+
+	cfg := &Config{
+		MaxExpiry:     100,
+		Cleanup:       200,
+		Backend:       memoryBackend,
+		VaryByCookies: []string{"locale", " locale"},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		cookie    string
+		wantBody  string
+		wantCalls int
+	}{
+		{cookie: "locale=fr; session=1", wantBody: "fr", wantCalls: 1},
+		{cookie: "session=2; locale=fr", wantBody: "fr", wantCalls: 1},
+		{cookie: "locale=fr", wantBody: "fr", wantCalls: 1},
+		{cookie: "locale=de; session=1", wantBody: "de", wantCalls: 2},
+		{cookie: "session=1", wantBody: "en", wantCalls: 3},
+		{cookie: "", wantBody: "en", wantCalls: 3},
+		{cookie: "malformed; Locale=fr", wantBody: "en", wantCalls: 3},
+	}
+
+	for _, test := range requests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		if test.cookie != "" {
+			req.Header.Set("Cookie", test.cookie)
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if calls != test.wantCalls {
+			t.Errorf("unexpected next handler calls for %q: want %d, got %d", test.cookie, test.wantCalls, calls)
+		}
+		if rw.Body.String() != test.wantBody {
+			t.Errorf("unexpected body for %q: want %q, got %q", test.cookie, test.wantBody, rw.Body.String())
+		}
+	}
+}
+
+func TestCache_CacheKeyMaxLength(t *testing.T) {
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: memoryBackend, MaxKeyLength: 256}
+
+	h, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	long := "http://localhost/some/path?q=" + strings.Repeat("é", 10000)
+
+	short := c.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost/some/path?q=1", nil))
+	if short != "simplecache:GETlocalhost/some/path?q=1" {
+		t.Errorf("unexpected short key: %q", short)
+	}
+
+	a := c.cacheKey(httptest.NewRequest(http.MethodGet, long, nil))
+	b := c.cacheKey(httptest.NewRequest(http.MethodGet, long, nil))
+	other := c.cacheKey(httptest.NewRequest(http.MethodGet, long+"x", nil))
+
+	if len(a) > cfg.MaxKeyLength {
+		t.Errorf("unexpected key length: want at most %d, got %d", cfg.MaxKeyLength, len(a))
+	}
+	if a != b {
+		t.Errorf("unexpected unstable key: %q and %q", a, b)
+	}
+	if a == other {
+		t.Errorf("unexpected key collision: %q", a)
+	}
+	if !strings.HasPrefix(a, "simplecache:GETlocalhost/some/path?q=") || !strings.Contains(a, hashedKeySuffix) {
+		t.Errorf("unexpected hashed key: %q", a)
+	}
+	if !utf8.ValidString(a) {
+		t.Errorf("unexpected invalid key: %q", a)
+	}
+}
+
+func TestCache_CacheKeyTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		tmpl      string
+		a, b      *http.Request
+		wantEqual bool
+	}{
+		{
+			name:      "should split keys on a custom header",
+			tmpl:      `{{.Method}}{{.Host}}{{.Path}}?{{.Query}}|{{.Header.Get "X-Region"}}`,
+			a:         newKeyRequest(http.MethodGet, "http://localhost/some/path", "X-Region", "eu"),
+			b:         newKeyRequest(http.MethodGet, "http://localhost/some/path", "X-Region", "us"),
+			wantEqual: false,
+		},
+		{
+			name:      "should share keys on the same custom header",
+			tmpl:      `{{.Method}}{{.Host}}{{.Path}}?{{.Query}}|{{.Header.Get "X-Region"}}`,
+			a:         newKeyRequest(http.MethodGet, "http://localhost/some/path", "X-Region", "eu"),
+			b:         newKeyRequest(http.MethodHead, "http://localhost/some/path", "X-Region", "eu"),
+			wantEqual: true,
+		},
+		{
+			name:      "should share keys between hosts",
+			tmpl:      `{{.Method}}{{.Path}}?{{.Query}}`,
+			a:         newKeyRequest(http.MethodGet, "http://a.example.com/some/path?a=1", "", ""),
+			b:         newKeyRequest(http.MethodGet, "http://b.example.com/some/path?a=1", "", ""),
+			wantEqual: true,
+		},
+		{
+			name:      "should split keys between hosts by default",
+			a:         newKeyRequest(http.MethodGet, "http://a.example.com/some/path?a=1", "", ""),
+			b:         newKeyRequest(http.MethodGet, "http://b.example.com/some/path?a=1", "", ""),
+			wantEqual: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := parseKeyTemplate(test.tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &cache{cfg: &Config{}, keyTmpl: tmpl}
+
+			a := c.cacheKey(test.a)
+			b := c.cacheKey(test.b)
+
+			if (a == b) != test.wantEqual {
+				t.Errorf("unexpected key equality: want %t, got %q and %q", test.wantEqual, a, b)
+			}
+		})
+	}
+}
+
+func newKeyRequest(method, target, header, value string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	return req
+}
+
+func TestCache_ServeHTTPKeyPrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b       string
+		wantShared bool
+	}{
+		{
+			name: "should isolate instances with different prefixes",
+			a:    "staging",
+			b:    "prod",
+		},
+		{
+			name: "should default to the name of the middleware",
+		},
+		{
+			name:       "should share entries with the same prefix",
+			a:          "shared",
+			b:          "shared",
+			wantShared: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newMemoryCache(0)
+
+			newInstance := func(prefix, name string) *cache {
+				next := func(rw http.ResponseWriter, req *http.Request) {
+					rw.Header().Set("Cache-Control", "max-age=20")
+					_, _ = rw.Write([]byte(name))
+				}
+
+				cfg := &Config{MaxExpiry: 100, Cleanup: cleanupDisabled, KeyPrefix: prefix}
+
+				c, err := newCache(context.Background(), http.HandlerFunc(next), cfg, name, b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return c
+			}
+
+			a, other := newInstance(test.a, "a"), newInstance(test.b, "b")
+
+			for _, c := range []*cache{a, other} {
+				c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+			}
+
+			rw := httptest.NewRecorder()
+			other.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			want := "b"
+			if test.wantShared {
+				want = "a"
+			}
+			if body := rw.Body.String(); body != want {
+				t.Errorf("unexpected body: want %q, got %q", want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+)
+
+// newLogger returns the logger of the middleware called name. It writes to the
+// output of the standard logger, at the configured level or, without one, at
+// the debug level in debug mode and the warn level otherwise.
+func newLogger(cfg *Config, name string) (*slog.Logger, error) {
+	level := slog.LevelWarn
+	if cfg.Debug {
+		level = slog.LevelDebug
+	}
+
+	if cfg.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+			return nil, fmt.Errorf("invalid logLevel: %w", err)
+		}
+	}
+
+	h := slog.NewTextHandler(log.Writer(), &slog.HandlerOptions{Level: level})
+
+	return slog.New(h).With("middleware", name), nil
+}
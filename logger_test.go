@@ -0,0 +1,104 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       *Config
+		wantLevel slog.Level
+	}{
+		{
+			name:      "should only log warnings by default",
+			cfg:       &Config{},
+			wantLevel: slog.LevelWarn,
+		},
+		{
+			name:      "should log debug messages in debug mode",
+			cfg:       &Config{Debug: true},
+			wantLevel: slog.LevelDebug,
+		},
+		{
+			name:      "should use the configured level",
+			cfg:       &Config{Debug: true, LogLevel: "info"},
+			wantLevel: slog.LevelInfo,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger, err := newLogger(test.cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			if !logger.Enabled(ctx, test.wantLevel) || logger.Enabled(ctx, test.wantLevel-1) {
+				t.Errorf("unexpected minimum level: want %v", test.wantLevel)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPStructuredLog(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("some body"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	var buf bytes.Buffer
+	c.logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	var hit map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]interface{}
+		if err = dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] == "Served cached response" {
+			hit = record
+		}
+	}
+
+	if hit == nil {
+		t.Fatal("expected a log record for the cache hit")
+	}
+
+	want := map[string]interface{}{
+		"level":  "DEBUG",
+		"key":    c.cacheKey(req),
+		"status": float64(http.StatusOK),
+		"bytes":  float64(len("some body")),
+	}
+	for name, val := range want {
+		if hit[name] != val {
+			t.Errorf("unexpected %s attribute: want %v, got %v", name, val, hit[name])
+		}
+	}
+	if _, ok := hit["ttl"]; !ok {
+		t.Errorf("expected a ttl attribute, got %v", hit)
+	}
+}
@@ -0,0 +1,145 @@
+package plugin_simplecache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	key     string
+	val     []byte
+	expires time.Time
+}
+
+// memoryCache is an in-memory backend holding at most maxBytes of keys and
+// values. The least recently used entries are evicted first.
+type memoryCache struct {
+	evictionCounter
+
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	lru      *list.List
+	clock    clock
+}
+
+func newMemoryCache(maxBytes int64) *memoryCache {
+	return &memoryCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		clock:    realClock{},
+	}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, errCacheMiss
+	}
+
+	e := el.Value.(*memoryEntry)
+	if !e.expires.After(c.clock.Now()) {
+		c.remove(el)
+		c.evicted()
+		return nil, errCacheMiss
+	}
+
+	c.lru.MoveToFront(el)
+
+	return e.val, nil
+}
+
+func (c *memoryCache) Set(key string, val []byte, expiry time.Duration) error {
+	e := &memoryEntry{
+		key:     key,
+		val:     append([]byte(nil), val...),
+		expires: c.clock.Now().Add(expiry),
+	}
+
+	size := entrySize(e)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return errors.New("entry exceeds the memory cache size")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+
+	for c.maxBytes > 0 && c.size+size > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.evicted()
+	}
+
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += size
+
+	return nil
+}
+
+func (c *memoryCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return errCacheMiss
+	}
+
+	c.remove(el)
+
+	return nil
+}
+
+func (c *memoryCache) removeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if !el.Value.(*memoryEntry).expires.After(now) {
+			c.remove(el)
+			c.evicted()
+		}
+		el = prev
+	}
+}
+
+func (c *memoryCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+
+	return nil
+}
+
+// remove deletes el from the cache. It must be called with c.mu held.
+func (c *memoryCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*memoryEntry)
+	delete(c.entries, e.key)
+	c.size -= entrySize(e)
+}
+
+func entrySize(e *memoryEntry) int64 {
+	return int64(len(e.key) + len(e.val))
+}
+
+func (c *memoryCache) usage() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), c.size
+}
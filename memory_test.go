@@ -0,0 +1,61 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache_LRU(t *testing.T) {
+	mc := newMemoryCache(30)
+
+	for i := 0; i < 3; i++ {
+		if err := mc.Set(fmt.Sprintf("key%d", i), []byte("value"), time.Minute); err != nil {
+			t.Fatalf("unexpected set error: %v", err)
+		}
+	}
+
+	// key0 becomes the most recently used entry, so key1 is the first to go.
+	if _, err := mc.Get("key0"); err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+
+	if err := mc.Set("key3", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+
+	for key, want := range map[string]error{"key0": nil, "key1": errCacheMiss, "key2": nil, "key3": nil} {
+		if _, err := mc.Get(key); !errors.Is(err, want) {
+			t.Errorf("unexpected get error for %q: want %v, got %v", key, want, err)
+		}
+	}
+
+	if mc.size > mc.maxBytes {
+		t.Errorf("unexpected size: want at most %d, got %d", mc.maxBytes, mc.size)
+	}
+
+	if err := mc.Set("key4", make([]byte, 64), time.Minute); err == nil {
+		t.Error("expected an error for an entry larger than the cache")
+	}
+}
+
+func TestMemoryCache_RemoveExpired(t *testing.T) {
+	mc := newMemoryCache(0)
+
+	clk := newFakeClock()
+	mc.clock = clk
+
+	_ = mc.Set("short", []byte("value"), time.Second)
+	_ = mc.Set("long", []byte("value"), time.Minute)
+
+	clk.Advance(time.Second)
+	mc.removeExpired()
+
+	if _, ok := mc.entries["short"]; ok {
+		t.Error("expected expired entry to be removed")
+	}
+	if _, ok := mc.entries["long"]; !ok {
+		t.Error("expected fresh entry to be kept")
+	}
+}
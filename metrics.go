@@ -0,0 +1,42 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serveMetrics writes the cache counters in the Prometheus text format.
+func (m *cache) serveMetrics(w http.ResponseWriter) {
+	var entries int
+	var bytes int64
+	if s, ok := m.cache.(sizer); ok {
+		entries, bytes = s.usage()
+	}
+
+	labels := `{name="` + labelEscaper.Replace(m.name) + `"}`
+
+	metrics := []struct {
+		name, typ, help string
+		value           interface{}
+	}{
+		{"cache_hits_total", "counter", "Requests served from the cache.", atomic.LoadUint64(&m.stats.hits)},
+		{"cache_misses_total", "counter", "Requests forwarded to the origin.", atomic.LoadUint64(&m.stats.misses)},
+		{"cache_errors_total", "counter", "Cache entries that could not be read.", atomic.LoadUint64(&m.stats.errors)},
+		{"cache_bytes_stored", "gauge", "Bytes held by the cache backend.", bytes},
+		{"cache_entries", "gauge", "Entries held by the cache backend.", entries},
+	}
+
+	var b strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s%s %d\n",
+			metric.name, metric.help, metric.name, metric.typ, metric.name, labels, metric.value)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
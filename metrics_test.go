@@ -0,0 +1,72 @@
+package plugin_simplecache
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCache_ServeMetrics(t *testing.T) {
+	dir := createTempDir(t)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, MetricsPath: "/metrics"}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "metrics-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/a", "/b", "/a", "/a"} {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/metrics", nil))
+
+	if calls != 2 {
+		t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+	}
+	if ct := rw.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type: %q", ct)
+	}
+
+	got := make(map[string]string)
+	sc := bufio.NewScanner(rw.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("malformed sample: %q", line)
+		}
+		got[fields[0]] = fields[1]
+	}
+
+	want := map[string]string{
+		`cache_hits_total{name="metrics-test"}`:   "2",
+		`cache_misses_total{name="metrics-test"}`: "2",
+		`cache_errors_total{name="metrics-test"}`: "0",
+		`cache_entries{name="metrics-test"}`:      "2",
+	}
+	for series, val := range want {
+		if got[series] != val {
+			t.Errorf("unexpected %s: want %s, got %q", series, val, got[series])
+		}
+	}
+	if v := got[`cache_bytes_stored{name="metrics-test"}`]; v == "" || v == "0" {
+		t.Errorf("expected stored bytes to be reported, got %q", v)
+	}
+}
@@ -0,0 +1,41 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compilePaths compiles the path patterns of the option called name.
+func compilePaths(name string, patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", name, pattern, err)
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// matchPath reports whether path matches any of the patterns.
+func matchPath(patterns []*regexp.Regexp, path string) bool {
+	for _, re := range patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bypassed reports whether requests for path skip the cache, either because
+// it is a no cache path or because it is not one of the only cached paths.
+func (m *cache) bypassed(path string) bool {
+	if matchPath(m.bypass, path) {
+		return true
+	}
+
+	return len(m.only) > 0 && !matchPath(m.only, path)
+}
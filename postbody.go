@@ -0,0 +1,70 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxKeyBodyBytes bounds the request bodies hashed into cache keys. Requests
+// with larger bodies are not cached.
+const maxKeyBodyBytes = 1 << 20
+
+// bodyKeyed reports whether r is a POST request whose body is part of its cache
+// key, such as a GraphQL query.
+func (m *cache) bodyKeyed(r *http.Request) bool {
+	return m.cfg.CachePostBody && r.Method == http.MethodPost && matchPath(m.postPaths, r.URL.Path)
+}
+
+// bufferBody reads the body of r so it can be hashed into the cache key, and
+// restores it for the next handler through r.Body and r.GetBody. It reports
+// false when the body exceeds maxKeyBodyBytes, leaving r.Body readable in full.
+func bufferBody(r *http.Request) (bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		r.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return true, nil
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxKeyBodyBytes+1))
+	if err != nil {
+		return false, err
+	}
+
+	if len(b) > maxKeyBodyBytes {
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(b), r.Body), Closer: r.Body}
+		return false, nil
+	}
+
+	_ = r.Body.Close()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	return true, nil
+}
+
+// bodyHash returns the hex SHA-256 of the buffered body of a body keyed
+// request, or an empty string for other requests.
+func bodyHash(r *http.Request) string {
+	if r.Method != http.MethodPost || r.GetBody == nil {
+		return ""
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = body.Close() }()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, body); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
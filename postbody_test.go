@@ -0,0 +1,78 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCache_ServeHTTPPostBody(t *testing.T) {
+	const (
+		queryA = `{"query":"{ product(id: 1) { name } }"}`
+		queryB = `{"query":"{ product(id: 2) { name } }"}`
+	)
+
+	var received []string
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		received = append(received, string(b))
+
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(b)
+	}
+
+	cfg := &Config{
+		MaxExpiry:       100,
+		Cleanup:         200,
+		AddStatusHeader: true,
+		Backend:         memoryBackend,
+		CachePostBody:   true,
+		PostBodyPaths:   []string{"^/graphql$"},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus string
+		wantNext   bool
+	}{
+		{name: "should miss a new query", path: "/graphql", body: queryA, wantStatus: "simplecache; fwd=miss; stored", wantNext: true},
+		{name: "should hit an identical query", path: "/graphql", body: queryA, wantStatus: "simplecache; hit; ttl=20"},
+		{name: "should miss a different query", path: "/graphql", body: queryB, wantStatus: "simplecache; fwd=miss; stored", wantNext: true},
+		{name: "should not cache other paths", path: "/orders", body: queryA, wantNext: true},
+		{name: "should not cache other paths again", path: "/orders", body: queryA, wantNext: true},
+	}
+
+	for _, test := range tests {
+		received = nil
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "http://localhost"+test.path, strings.NewReader(test.body)))
+
+		if got := rw.Header().Get(cacheHeader); got != test.wantStatus {
+			t.Errorf("%s: unexpected cache status: want %q, got %q", test.name, test.wantStatus, got)
+		}
+		if rw.Body.String() != test.body {
+			t.Errorf("%s: unexpected response body: want %q, got %q", test.name, test.body, rw.Body.String())
+		}
+		if test.wantNext && (len(received) != 1 || received[0] != test.body) {
+			t.Errorf("%s: expected the next handler to receive the full body, got %q", test.name, received)
+		}
+		if !test.wantNext && len(received) != 0 {
+			t.Errorf("%s: expected the next handler not to be called, got %q", test.name, received)
+		}
+	}
+}
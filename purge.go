@@ -0,0 +1,192 @@
+package plugin_simplecache
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	methodPurge       = "PURGE"
+	purgeTokenHeader  = "X-Purge-Token"
+	purgeAllHeader    = "X-Purge-All"
+	purgeSoftHeader   = "X-Purge-Soft"
+	purgePrefixHeader = "X-Purge-Prefix"
+)
+
+// purge removes the entry stored for the URL of the PURGE request r, or all
+// entries when the purge all header is set. With the soft purge header, the
+// entry is marked stale instead of removed. With the prefix purge header, all
+// the entries whose path starts with the one of r are removed. The purge
+// headers are not part of the key, the other request headers select the entry
+// like they would for a GET.
+func (m *cache) purge(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(purgeTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(m.cfg.PurgeAuthToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if all, _ := strconv.ParseBool(r.Header.Get(purgeAllHeader)); all {
+		m.clear(w)
+		return
+	}
+
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Header.Del(purgeTokenHeader)
+	req.Header.Del(purgeAllHeader)
+	req.Header.Del(purgeSoftHeader)
+	req.Header.Del(purgePrefixHeader)
+
+	if prefix, _ := strconv.ParseBool(r.Header.Get(purgePrefixHeader)); prefix {
+		m.purgePrefix(w, req)
+		return
+	}
+
+	key := m.cacheKey(req)
+
+	if soft, _ := strconv.ParseBool(r.Header.Get(purgeSoftHeader)); soft {
+		m.softPurge(w, req, key)
+		return
+	}
+
+	// Deleting a vary manifest makes all of its variants unreachable.
+	err := m.cache.Delete(key)
+	switch {
+	case err == nil:
+		m.logger.Info("Purged cache item", "key", key)
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, errCacheMiss):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		m.logger.Error("Error purging cache item", "key", key, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// softPurge expires the entry stored for key, which is kept for as long as it
+// can be revalidated or served stale. Of a vary manifest, only the variant
+// selected by the headers of r is expired.
+func (m *cache) softPurge(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := m.open(key)
+	if err == nil && len(data.Vary) > 0 {
+		data.close()
+		key = varyKey(key, data.Vary, r)
+		data, err = m.open(key)
+	}
+	if err == nil {
+		err = data.load()
+	}
+
+	switch {
+	case errors.Is(err, errCacheMiss):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		m.logger.Error("Error reading cache item", "key", key, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data.Expires = m.clock.Now()
+
+	// An entry that cannot be used once stale is removed.
+	if ttl := m.retention(data); ttl > 0 {
+		err = m.set(key, *data, ttl)
+	} else {
+		err = m.cache.Delete(key)
+	}
+	if err != nil && !errors.Is(err, errCacheMiss) {
+		m.logger.Error("Error purging cache item", "key", key, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	m.logger.Info("Soft purged cache item", "key", key)
+	w.WriteHeader(http.StatusOK)
+}
+
+// purgePrefix removes the entries stored for the paths starting with the one of
+// r at a segment boundary, on the same host. Only the default key format can be matched, and the
+// backend must be able to list its entries.
+func (m *cache) purgePrefix(w http.ResponseWriter, r *http.Request) {
+	if m.keyTmpl != nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	entries, err := m.Entries()
+	switch {
+	case errors.Is(err, errListUnsupported):
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	case err != nil:
+		m.logger.Error("Error listing cache entries", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	prefix := m.keyPrefix + m.pathKey(r.Method, r)
+
+	var purged int
+	for _, e := range entries {
+		if !hasPathPrefix(e.Key, prefix) {
+			continue
+		}
+
+		keys := []string{e.Key}
+		// The vary manifest of a variant is not listed, but would be left
+		// pointing to nothing.
+		if i := strings.Index(e.Key, "|Vary"); i >= 0 {
+			keys = append(keys, e.Key[:i])
+		}
+
+		for _, key := range keys {
+			if err = m.cache.Delete(key); err != nil && !errors.Is(err, errCacheMiss) {
+				m.logger.Error("Error purging cache item", "key", key, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		purged++
+	}
+
+	if purged == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	m.logger.Info("Purged cache items", "prefix", prefix, "count", purged)
+	w.WriteHeader(http.StatusOK)
+}
+
+// hasPathPrefix reports whether key starts with the path key prefix, followed by
+// the end of the path, its query, its other parts or another path segment. A
+// purge of /blog leaves /blogger alone, also when the trailing slash of /blog/
+// was normalized away.
+func hasPathPrefix(key, prefix string) bool {
+	if !strings.HasPrefix(key, prefix) {
+		return false
+	}
+
+	rest := key[len(prefix):]
+	if rest == "" || strings.HasSuffix(prefix, "/") {
+		return true
+	}
+
+	return strings.ContainsRune("/?|", rune(rest[0]))
+}
+
+func (m *cache) clear(w http.ResponseWriter) {
+	if err := m.cache.Clear(); err != nil {
+		m.logger.Error("Error clearing cache", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	m.logger.Info("Cleared the cache")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,324 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCache_ServeHTTPPurge(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		token     string
+		wantCode  int
+		wantCalls int
+	}{
+		{
+			name:      "should purge a stored entry",
+			path:      "/some/path",
+			token:     "secret",
+			wantCode:  http.StatusOK,
+			wantCalls: 2,
+		},
+		{
+			name:      "should report a missing entry",
+			path:      "/other/path",
+			token:     "secret",
+			wantCode:  http.StatusNotFound,
+			wantCalls: 1,
+		},
+		{
+			name:      "should reject a purge without the token",
+			path:      "/some/path",
+			wantCode:  http.StatusUnauthorized,
+			wantCalls: 1,
+		},
+		{
+			name:      "should reject a purge with a wrong token",
+			path:      "/some/path",
+			token:     "guess",
+			wantCode:  http.StatusUnauthorized,
+			wantCalls: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, PurgeAuthToken: "secret"}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			req := httptest.NewRequest(methodPurge, "http://localhost"+test.path, nil)
+			if test.token != "" {
+				req.Header.Set(purgeTokenHeader, test.token)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPSoftPurge(t *testing.T) {
+	tests := []struct {
+		name              string
+		cfg               Config
+		wantFetches       int
+		wantRevalidations int
+		wantStatus        string
+	}{
+		{
+			name:              "should revalidate a soft purged entry",
+			cfg:               Config{Revalidate: true},
+			wantFetches:       1,
+			wantRevalidations: 1,
+			wantStatus:        "simplecache; fwd=stale; fwd-status=304; stored",
+		},
+		{
+			name:        "should fetch a soft purged entry that cannot be revalidated",
+			wantFetches: 2,
+			wantStatus:  "simplecache; fwd=miss; stored",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fetches, revalidations int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.Header().Set("ETag", `"v1"`)
+				if req.Header.Get("If-None-Match") == `"v1"` {
+					revalidations++
+					rw.WriteHeader(http.StatusNotModified)
+					return
+				}
+				fetches++
+				_, _ = rw.Write([]byte("v1"))
+			}
+
+			cfg := test.cfg
+			cfg.MaxExpiry = 10
+			cfg.Cleanup = 20
+			cfg.Backend = memoryBackend
+			cfg.AddStatusHeader = true
+			cfg.PurgeAuthToken = "secret"
+
+			c, err := New(context.Background(), http.HandlerFunc(next), &cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			req := httptest.NewRequest(methodPurge, "http://localhost/some/path", nil)
+			req.Header.Set(purgeTokenHeader, "secret")
+			req.Header.Set(purgeSoftHeader, "true")
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != http.StatusOK {
+				t.Errorf("unexpected purge status code: want %d, got %d", http.StatusOK, rw.Code)
+			}
+
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if rw.Code != http.StatusOK || rw.Body.String() != "v1" {
+				t.Errorf("unexpected response: got status %d and body %q", rw.Code, rw.Body.String())
+			}
+			if status := rw.Header().Get(cacheHeader); status != test.wantStatus {
+				t.Errorf("unexpected cache status: want %q, got %q", test.wantStatus, status)
+			}
+			if fetches != test.wantFetches || revalidations != test.wantRevalidations {
+				t.Errorf("unexpected origin requests: want %d fetches and %d revalidations, got %d and %d",
+					test.wantFetches, test.wantRevalidations, fetches, revalidations)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPPurgeAll(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, PurgeAuthToken: "secret"}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{"/a", "/b", "/c"}
+	for _, path := range paths {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+	}
+
+	req := httptest.NewRequest(methodPurge, "http://localhost/", nil)
+	req.Header.Set(purgeAllHeader, "true")
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status code without token: want %d, got %d", http.StatusUnauthorized, rw.Code)
+	}
+
+	req.Header.Set(purgeTokenHeader, "secret")
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusOK, rw.Code)
+	}
+
+	for _, path := range paths {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+	}
+
+	if want := 2 * len(paths); calls != want {
+		t.Errorf("unexpected next handler calls: want %d, got %d", want, calls)
+	}
+}
+
+func TestCache_ServeHTTPPurgeDisabled(t *testing.T) {
+	var methods []string
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		methods = append(methods, req.Method)
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(methodPurge, "http://localhost/some/path", nil)
+	req.Header.Set(purgeTokenHeader, "")
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if len(methods) != 1 || methods[0] != methodPurge {
+		t.Errorf("expected PURGE to be passed to the next handler without a token configured, got %v", methods)
+	}
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+}
+
+func TestCache_ServeHTTPPurgePrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		path string
+	}{
+		{name: "should purge the paths under a directory", path: "/blog/"},
+		{name: "should purge the paths under a path", path: "/blog"},
+		{name: "should purge the paths under a normalized directory", cfg: Config{NormalizeTrailingSlash: true}, path: "/blog/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=20")
+				if req.URL.Path == "/blog/varied" {
+					rw.Header().Set("Vary", "Accept-Language")
+				}
+				_, _ = rw.Write([]byte("content"))
+			}
+
+			cfg := test.cfg
+			cfg.Path = createTempDir(t)
+			cfg.MaxExpiry = 10
+			cfg.Cleanup = 20
+			cfg.PurgeAuthToken = "secret"
+
+			h, err := New(context.Background(), http.HandlerFunc(next), &cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			paths := []string{"/blog/", "/blog/first", "/blog/second?page=2", "/blog/varied", "/blogger", "/blog-archive", "/about", "/"}
+			for _, path := range paths {
+				c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+			}
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://other/blog/first", nil))
+
+			req := httptest.NewRequest(methodPurge, "http://localhost"+test.path, nil)
+			req.Header.Set(purgeTokenHeader, "secret")
+			req.Header.Set(purgePrefixHeader, "true")
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != http.StatusOK {
+				t.Errorf("unexpected status code: want %d, got %d", http.StatusOK, rw.Code)
+			}
+
+			entries, err := c.Entries()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var keys []string
+			for _, e := range entries {
+				keys = append(keys, e.Key)
+			}
+			want := []string{
+				"simplecache:GETlocalhost/?",
+				"simplecache:GETlocalhost/about?",
+				"simplecache:GETlocalhost/blog-archive?",
+				"simplecache:GETlocalhost/blogger?",
+				"simplecache:GETother/blog/first?",
+			}
+			if !reflect.DeepEqual(keys, want) {
+				t.Errorf("unexpected entries after the purge: want %q, got %q", want, keys)
+			}
+
+			if _, err = c.get("simplecache:GETlocalhost/blog/varied?"); err != errCacheMiss {
+				t.Errorf("unexpected vary manifest error: want %v, got %v", errCacheMiss, err)
+			}
+
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != http.StatusNotFound {
+				t.Errorf("unexpected status code of a second purge: want %d, got %d", http.StatusNotFound, rw.Code)
+			}
+		})
+	}
+}
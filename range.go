@@ -0,0 +1,20 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// serveRange serves the byte ranges of data requested by r with a 206, or a
+// 416 when none of them can be satisfied. Multiple ranges are served as a
+// multipart/byteranges response.
+func (m *cache) serveRange(w http.ResponseWriter, r *http.Request, key string, data *cacheData) {
+	if err := data.load(); err != nil {
+		m.logger.Error("Error reading cache item", "key", key, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data.Body))
+}
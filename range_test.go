@@ -0,0 +1,165 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestCache_ServeHTTPRange(t *testing.T) {
+	tests := []struct {
+		name             string
+		rng              string
+		wantCode         int
+		wantBody         string
+		wantContentRange string
+	}{
+		{
+			name:     "should serve the full body without a range",
+			wantCode: http.StatusOK,
+			wantBody: "0123456789",
+		},
+		{
+			name:             "should serve a single range",
+			rng:              "bytes=2-5",
+			wantCode:         http.StatusPartialContent,
+			wantBody:         "2345",
+			wantContentRange: "bytes 2-5/10",
+		},
+		{
+			name:             "should serve an open-ended range",
+			rng:              "bytes=7-",
+			wantCode:         http.StatusPartialContent,
+			wantBody:         "789",
+			wantContentRange: "bytes 7-9/10",
+		},
+		{
+			name:             "should serve a suffix range",
+			rng:              "bytes=-2",
+			wantCode:         http.StatusPartialContent,
+			wantBody:         "89",
+			wantContentRange: "bytes 8-9/10",
+		},
+		{
+			name:             "should reject an unsatisfiable range",
+			rng:              "bytes=20-30",
+			wantCode:         http.StatusRequestedRangeNotSatisfiable,
+			wantContentRange: "bytes */10",
+		},
+		{
+			name:     "should reject a malformed range",
+			rng:      "bytes=5-2",
+			wantCode: http.StatusRequestedRangeNotSatisfiable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.Header().Set("Content-Type", "text/plain")
+				rw.Header().Set("Content-Length", "10")
+				_, _ = rw.Write([]byte("0123456789"))
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			if test.rng != "" {
+				req.Header.Set("Range", test.rng)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if calls != 1 {
+				t.Errorf("unexpected next handler calls: want 1, got %d", calls)
+			}
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+			}
+			if got := rw.Header().Get("Content-Range"); got != test.wantContentRange {
+				t.Errorf("unexpected content range: want %q, got %q", test.wantContentRange, got)
+			}
+			if test.wantCode == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected body: want %q, got %q", test.wantBody, body)
+			}
+			if cl := rw.Header().Get("Content-Length"); cl != strconv.Itoa(len(test.wantBody)) {
+				t.Errorf("unexpected content length: want %d, got %q", len(test.wantBody), cl)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPMultiRange(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Content-Type", "text/plain")
+		_, _ = rw.Write([]byte("0123456789"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	req.Header.Set("Range", "bytes=0-1,8-9")
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusPartialContent {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusPartialContent, rw.Code)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(rw.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("unexpected content type: %q", rw.Header().Get("Content-Type"))
+	}
+
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 8-9/10", "89"},
+	}
+
+	mr := multipart.NewReader(rw.Body, params["boundary"])
+	for _, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cr := part.Header.Get("Content-Range"); cr != w.contentRange || string(body) != w.body {
+			t.Errorf("unexpected part: want %s %q, got %s %q", w.contentRange, w.body, cr, body)
+		}
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Errorf("unexpected trailing part: %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package plugin_simplecache
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	redisTimeout  = 5 * time.Second
+	redisMaxIdle  = 8
+	redisScanSize = 100
+)
+
+// errRedisNil is the reply to a GET of a missing key.
+var errRedisNil = errors.New("redis: nil")
+
+// redisCache is a backend storing entries in Redis, so they are shared by all
+// instances using the same server. Entries expire through the Redis key expiry.
+// It speaks the few commands it needs of the Redis protocol directly.
+type redisCache struct {
+	evictionCounter
+
+	addr     string
+	password string
+	db       int
+
+	// prefix namespaces the keys of the plugin on the server. The keys of the
+	// middlewares are told apart by their keyPrefix, which is part of the key.
+	prefix string
+
+	idle chan *redisConn
+}
+
+func newRedisCache(addr, password string, db int) *redisCache {
+	return &redisCache{
+		addr:     addr,
+		password: password,
+		db:       db,
+		prefix:   "simplecache:",
+		idle:     make(chan *redisConn, redisMaxIdle),
+	}
+}
+
+// redisKey returns the Redis key of the entry for key.
+func (c *redisCache) redisKey(key string) string {
+	h := keyHash(key)
+	return c.prefix + hex.EncodeToString(h[:])
+}
+
+func (c *redisCache) Get(key string) ([]byte, error) {
+	v, err := c.do("GET", c.redisKey(key))
+	if errors.Is(err, errRedisNil) {
+		return nil, errCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting cache item: %w", err)
+	}
+
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("error getting cache item: unexpected reply %v", v)
+	}
+
+	return b, nil
+}
+
+func (c *redisCache) Set(key string, val []byte, expiry time.Duration) error {
+	ms := expiry.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	if _, err := c.do("SET", c.redisKey(key), string(val), "PX", strconv.FormatInt(ms, 10)); err != nil {
+		return fmt.Errorf("error setting cache item: %w", err)
+	}
+
+	return nil
+}
+
+func (c *redisCache) Delete(key string) error {
+	v, err := c.do("DEL", c.redisKey(key))
+	if err != nil {
+		return fmt.Errorf("error removing cache item: %w", err)
+	}
+
+	if n, _ := v.(int64); n == 0 {
+		return errCacheMiss
+	}
+
+	return nil
+}
+
+// Clear removes the keys of the middleware, leaving other keys of the database
+// untouched.
+func (c *redisCache) Clear() error {
+	cursor := "0"
+	for {
+		v, err := c.do("SCAN", cursor, "MATCH", c.prefix+"*", "COUNT", strconv.Itoa(redisScanSize))
+		if err != nil {
+			return fmt.Errorf("error clearing cache: %w", err)
+		}
+
+		reply, ok := v.([]interface{})
+		if !ok || len(reply) != 2 {
+			return fmt.Errorf("error clearing cache: unexpected reply %v", v)
+		}
+		next, _ := reply[0].([]byte)
+		keys, _ := reply[1].([]interface{})
+
+		if len(keys) > 0 {
+			args := make([]string, 0, len(keys))
+			for _, k := range keys {
+				if b, ok := k.([]byte); ok {
+					args = append(args, string(b))
+				}
+			}
+			if _, err = c.do("DEL", args...); err != nil {
+				return fmt.Errorf("error clearing cache: %w", err)
+			}
+		}
+
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// check reports whether the server answers.
+func (c *redisCache) check() error {
+	if _, err := c.do("PING"); err != nil {
+		return fmt.Errorf("error pinging redis: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the idle connections. Later commands dial new connections.
+func (c *redisCache) Close() error {
+	for {
+		select {
+		case conn := <-c.idle:
+			_ = conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do runs a command on an idle connection, dialing a new one if there is none.
+// Connections are only reused after a complete reply.
+func (c *redisCache) do(cmd string, args ...string) (interface{}, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := conn.do(cmd, args...)
+
+	var redisErr redisError
+	if err != nil && !errors.Is(err, errRedisNil) && !errors.As(err, &redisErr) {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.idle <- conn:
+	default:
+		_ = conn.Close()
+	}
+
+	return v, err
+}
+
+func (c *redisCache) conn() (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if c.password != "" {
+		if _, err = conn.do("AUTH", c.password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if c.db != 0 {
+		if _, err = conn.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command and reads its reply. Bulk strings are returned as []byte,
+// integers as int64 and arrays as []interface{}.
+func (c *redisConn) do(cmd string, args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)+1), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range append([]string{cmd}, args...) {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return readReply(c.r)
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed reply length %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed reply length %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		vals := make([]interface{}, n)
+		for i := range vals {
+			if vals[i], err = readReply(r); err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+		}
+		return vals, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,217 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireAuth("secret")
+
+	rc := newRedisCache(srv.Addr(), "secret", 2)
+
+	content := []byte("some \r\nbinary\x00 content")
+
+	if _, err := rc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected get error on empty backend: want %v, got %v", errCacheMiss, err)
+	}
+
+	if err := rc.Set(testCacheKey, content, 10*time.Second); err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+
+	got, err := rc.Get(testCacheKey)
+	if err != nil {
+		t.Fatalf("unexpected get error: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("unexpected content: want %q, got %q", content, got)
+	}
+
+	keys := srv.DB(2).Keys()
+	if len(keys) != 1 || keys[0] != rc.redisKey(testCacheKey) || !strings.HasPrefix(keys[0], "simplecache:") {
+		t.Errorf("unexpected keys in the selected database: %v", keys)
+	}
+	if ttl := srv.DB(2).TTL(keys[0]); ttl != 10*time.Second {
+		t.Errorf("unexpected key expiry: want %v, got %v", 10*time.Second, ttl)
+	}
+
+	srv.FastForward(10 * time.Second)
+
+	if _, err = rc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected get error after expiry: want %v, got %v", errCacheMiss, err)
+	}
+
+	if err = rc.Set(testCacheKey, content, 10*time.Second); err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+	if err = rc.Delete(testCacheKey); err != nil {
+		t.Errorf("unexpected delete error: %v", err)
+	}
+	if _, err = rc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected get error after delete: want %v, got %v", errCacheMiss, err)
+	}
+	if err = rc.Delete(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected delete error on missing entry: want %v, got %v", errCacheMiss, err)
+	}
+}
+
+func TestRedisCache_Clear(t *testing.T) {
+	srv := miniredis.RunT(t)
+	if err := srv.Set("unrelated", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	rc := newRedisCache(srv.Addr(), "", 0)
+
+	keys := []string{testCacheKey, "other:" + testCacheKey}
+	for _, key := range keys {
+		if err := rc.Set(key, []byte("content"), time.Minute); err != nil {
+			t.Fatalf("unexpected set error: %v", err)
+		}
+	}
+
+	if err := rc.Clear(); err != nil {
+		t.Fatalf("unexpected clear error: %v", err)
+	}
+
+	for _, key := range keys {
+		if _, err := rc.Get(key); !errors.Is(err, errCacheMiss) {
+			t.Errorf("unexpected get error after clear: want %v, got %v", errCacheMiss, err)
+		}
+	}
+	if keys := srv.Keys(); len(keys) != 1 || keys[0] != "unrelated" {
+		t.Errorf("expected keys of other applications to be kept, got: %v", keys)
+	}
+}
+
+func TestRedisCache_Auth(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireAuth("secret")
+
+	rc := newRedisCache(srv.Addr(), "wrong", 0)
+
+	var redisErr redisError
+	if _, err := rc.Get(testCacheKey); !errors.As(err, &redisErr) {
+		t.Errorf("expected an authentication error, got: %v", err)
+	}
+}
+
+func TestRedisCache_ReusesConnections(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireAuth("secret")
+
+	rc := newRedisCache(srv.Addr(), "secret", 1)
+
+	for i := 0; i < 3; i++ {
+		if _, err := rc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+			t.Fatalf("unexpected get error: %v", err)
+		}
+	}
+
+	if n := srv.TotalConnectionCount(); n != 1 {
+		t.Errorf("unexpected connections: want 1, got %d", n)
+	}
+}
+
+func TestRedisCache_Check(t *testing.T) {
+	srv := miniredis.RunT(t)
+
+	rc := newRedisCache(srv.Addr(), "", 0)
+	if err := rc.check(); err != nil {
+		t.Errorf("unexpected check error: %v", err)
+	}
+
+	srv.Close()
+	_ = rc.Close()
+
+	if err := rc.check(); err == nil {
+		t.Error("expected a check error once the server is gone")
+	}
+}
+
+func TestCache_ServeHTTPRedis(t *testing.T) {
+	srv := miniredis.RunT(t)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("some body"))
+	}
+
+	newInstance := func() http.Handler {
+		cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: redisBackend, RedisAddr: srv.Addr(), AddStatusHeader: true}
+
+		h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	// Instances using the same server share their entries.
+	for i, h := range []http.Handler{newInstance(), newInstance()} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if rw.Body.String() != "some body" {
+			t.Errorf("unexpected body of instance %d: %q", i, rw.Body.String())
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("unexpected next handler calls: want 1, got %d", calls)
+	}
+	if keys := srv.Keys(); len(keys) != 1 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCache_ServeHTTPRedisKeyPrefix(t *testing.T) {
+	srv := miniredis.RunT(t)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("some body"))
+	}
+
+	newInstance := func(name, prefix string) http.Handler {
+		cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: redisBackend, RedisAddr: srv.Addr(), KeyPrefix: prefix}
+
+		h, err := New(context.Background(), http.HandlerFunc(next), cfg, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	// Differently named instances with the same prefix share their entries,
+	// another prefix does not.
+	for i, h := range []http.Handler{newInstance("a", "shared"), newInstance("b", "shared"), newInstance("c", "other")} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if rw.Body.String() != "some body" {
+			t.Errorf("unexpected body of instance %d: %q", i, rw.Body.String())
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+	}
+	if keys := srv.Keys(); len(keys) != 2 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
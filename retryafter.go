@@ -0,0 +1,48 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryAfter returns the delay set by the Retry-After header of h, either as
+// delta-seconds or as an HTTP-date relative to now, see RFC 9110 section 10.2.3.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, secs >= 0
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	return t.Sub(now), true
+}
+
+// retryAfterTTL returns the TTL of a 429 or 503 response, which is cached until
+// its Retry-After delay when enabled so the origin is shielded meanwhile. The
+// TTL is capped by maxExpiry.
+func (m *cache) retryAfterTTL(h http.Header, status int) (time.Duration, bool) {
+	if !m.cfg.CacheRetryAfter || (status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	ttl, ok := retryAfter(h, m.clock.Now())
+	if !ok || ttl <= 0 {
+		return 0, false
+	}
+
+	if maxExpiry := time.Duration(m.cfg.MaxExpiry) * time.Second; maxExpiry < ttl {
+		ttl = maxExpiry
+	}
+
+	return ttl, true
+}
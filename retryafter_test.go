@@ -0,0 +1,128 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "120", want: 2 * time.Minute, wantOK: true},
+		{value: " 30 ", want: 30 * time.Second, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: now.Add(time.Minute).UTC().Format(http.TimeFormat), want: time.Minute, wantOK: true},
+		{value: now.Add(-time.Minute).UTC().Format(time.RFC850), want: -time.Minute, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+
+	for _, test := range tests {
+		got, ok := retryAfter(http.Header{"Retry-After": {test.value}}, now)
+		if ok != test.wantOK || (ok && got != test.want) {
+			t.Errorf("unexpected delay of %q: want %v, %t, got %v, %t", test.value, test.want, test.wantOK, got, ok)
+		}
+	}
+}
+
+func TestCache_ServeHTTPRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		disabled   bool
+		wantTTL    time.Duration
+	}{
+		{
+			name:       "should cache a 503 for its delay in seconds",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "30",
+			wantTTL:    30 * time.Second,
+		},
+		{
+			name:       "should cache a 429 until its date",
+			status:     http.StatusTooManyRequests,
+			retryAfter: clock.Now().Add(40 * time.Second).UTC().Format(http.TimeFormat),
+			wantTTL:    40 * time.Second,
+		},
+		{
+			name:       "should cap the delay by maxExpiry",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "3600",
+			wantTTL:    100 * time.Second,
+		},
+		{
+			name:       "should not cache a 503 without a delay",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "",
+		},
+		{
+			name:       "should not cache a 503 when disabled",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "30",
+			disabled:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				if test.retryAfter != "" {
+					rw.Header().Set("Retry-After", test.retryAfter)
+				}
+				rw.WriteHeader(test.status)
+			}
+
+			cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: memoryBackend, CacheRetryAfter: !test.disabled}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+			clk := newFakeClock()
+			setClock(c, clk)
+
+			serve := func() int {
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+				return rw.Code
+			}
+
+			if code := serve(); code != test.status {
+				t.Errorf("unexpected status code: want %d, got %d", test.status, code)
+			}
+
+			if test.wantTTL == 0 {
+				serve()
+				if calls != 2 {
+					t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+				}
+				return
+			}
+
+			clk.Advance(test.wantTTL - time.Second)
+			if code := serve(); code != test.status || calls != 1 {
+				t.Errorf("expected a cached %d before the delay, got %d after %d calls", test.status, code, calls)
+			}
+
+			clk.Advance(time.Second)
+			serve()
+			if calls != 2 {
+				t.Errorf("unexpected next handler calls after the delay: want 2, got %d", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+// retention returns how long data is kept past its expiry so that it can
+// still be revalidated with the origin or served while being refreshed.
+func (m *cache) retention(data *cacheData) time.Duration {
+	var d time.Duration
+	if m.revalidatable(data) {
+		d = time.Duration(m.cfg.MaxExpiry) * time.Second
+	}
+
+	if data.MustRevalidate {
+		return d
+	}
+
+	if swr := time.Duration(m.cfg.StaleWhileRevalidate) * time.Second; swr > d {
+		d = swr
+	}
+
+	if soe := m.staleOnError(); soe > d {
+		d = soe
+	}
+
+	return d
+}
+
+// revalidatable reports whether the stale entry data can be revalidated, which
+// requires an ETag or a Last-Modified date.
+func (m *cache) revalidatable(data *cacheData) bool {
+	return m.cfg.Revalidate && (data.Headers.Get("ETag") != "" || data.Headers.Get("Last-Modified") != "")
+}
+
+// servableStale reports whether the stale entry data may be served while it is
+// refreshed in the background.
+func (m *cache) servableStale(data *cacheData) bool {
+	swr := time.Duration(m.cfg.StaleWhileRevalidate) * time.Second
+	return swr > 0 && !data.MustRevalidate && m.clock.Now().Before(data.Expires.Add(swr))
+}
+
+// immutable reports whether the response headers h mark it as immutable.
+func immutable(h http.Header) bool {
+	dir, err := cacheobject.ParseResponseCacheControl(h.Get("Cache-Control"))
+	return err == nil && dir.Immutable
+}
+
+// mustRevalidate reports whether the response headers h forbid serving it
+// stale, see RFC 9111 section 5.2.2.2. A shared cache treats proxy-revalidate
+// the same way.
+func mustRevalidate(h http.Header) bool {
+	dir, err := cacheobject.ParseResponseCacheControl(h.Get("Cache-Control"))
+	return err == nil && (dir.MustRevalidate || dir.ProxyRevalidate)
+}
+
+// serveImmutable serves the entry stored for key if it is fresh and immutable,
+// when the request asks for revalidation.
+func (m *cache) serveImmutable(w http.ResponseWriter, r *http.Request, key string) bool {
+	data, err := m.lookup(key, r)
+	defer data.close()
+
+	if err != nil || !data.Immutable || !m.fresh(data) {
+		return false
+	}
+
+	m.logger.Debug("Serving immutable response", "key", key)
+	m.serve(w, r, key, data, m.hitStatus(data))
+
+	return true
+}
+
+// revalidate refreshes the stale entry data and serves the result.
+func (m *cache) revalidate(w http.ResponseWriter, r *http.Request, key string, data *cacheData) {
+	refreshed, bw := m.refresh(r, key, data)
+	if refreshed != nil {
+		_, stored := m.cacheable(r, refreshed.Headers, refreshed.Status)
+		m.serve(w, r, key, refreshed, cacheStatus{fwd: fwdStale, fwdStatus: http.StatusNotModified, stored: stored})
+		return
+	}
+
+	if originFailed(bw.status) && m.servableOnError(data) {
+		m.logger.Warn("Origin failed, serving stale response", "key", key, "status", bw.status)
+		m.serve(w, r, key, data, cacheStatus{fwd: fwdStale, fwdStatus: bw.status, detail: detailStaleOnError})
+		return
+	}
+
+	_, stored := m.cacheable(r, bw.header, bw.status)
+	m.dropSurrogateControl(bw.header)
+	m.setCacheStatus(bw.header, cacheStatus{fwd: fwdStale, fwdStatus: bw.status, stored: stored})
+	bw.writeTo(w)
+}
+
+// staleOnError returns how long past its expiry an entry may be served when the
+// origin fails, defaulting to maxExpiry.
+func (m *cache) staleOnError() time.Duration {
+	if !m.cfg.ServeStaleOnError {
+		return 0
+	}
+	if m.cfg.MaxStaleOnError > 0 {
+		return time.Duration(m.cfg.MaxStaleOnError) * time.Second
+	}
+
+	return time.Duration(m.cfg.MaxExpiry) * time.Second
+}
+
+// servableOnError reports whether the stale entry data may be served in place of
+// a failed origin response.
+func (m *cache) servableOnError(data *cacheData) bool {
+	soe := m.staleOnError()
+	return soe > 0 && !data.MustRevalidate && m.clock.Now().Before(data.Expires.Add(soe))
+}
+
+// originFailed reports whether status indicates an origin failure, which
+// includes the gateway errors returned when the origin is unreachable or times
+// out.
+func originFailed(status int) bool {
+	return status >= http.StatusInternalServerError
+}
+
+// fetchOrStale fetches a new response for the stale entry data, serving data
+// instead when the origin fails.
+func (m *cache) fetchOrStale(w http.ResponseWriter, r *http.Request, key string, data *cacheData, cs cacheStatus) {
+	bw := &bufferWriter{header: make(http.Header)}
+	m.forward(bw, r)
+
+	if originFailed(bw.status) {
+		m.logger.Warn("Origin failed, serving stale response", "key", key, "status", bw.status)
+		m.serve(w, r, key, data, cacheStatus{fwd: fwdStale, fwdStatus: bw.status, detail: detailStaleOnError})
+		return
+	}
+
+	if cs.detail == "" {
+		atomic.AddUint64(&m.stats.misses, 1)
+	}
+
+	m.store(key, r, bw.status, bw.header, bw.body.Bytes())
+
+	_, cs.stored = m.cacheable(r, bw.header, bw.status)
+	m.dropSurrogateControl(bw.header)
+	m.setCacheStatus(bw.header, cs)
+	bw.writeTo(w)
+}
+
+// refreshInBackground refreshes the stale entry data without holding up the
+// current request. Only one refresh runs per key at a time, stale hits arriving
+// while it runs do not start another.
+func (m *cache) refreshInBackground(r *http.Request, key string, data *cacheData) {
+	// The refresh is tracked like the other background goroutines, so Close
+	// cancels it and waits for it to finish writing to the backend. None is
+	// started once Close began waiting.
+	m.closeMu.Lock()
+	if m.closed {
+		m.closeMu.Unlock()
+		return
+	}
+	m.wg.Add(1)
+	m.closeMu.Unlock()
+
+	req := r.Clone(m.ctx)
+
+	// The clone shares the body of r, which the server closes with the request.
+	if r.GetBody != nil {
+		if body, err := r.GetBody(); err == nil {
+			req.Body = body
+		}
+	}
+
+	started := m.flight.TryGo(key, func() {
+		defer m.wg.Done()
+		m.refresh(req, key, data)
+	})
+	if !started {
+		m.wg.Done()
+		m.logger.Debug("Refresh already in progress", "key", key)
+	}
+}
+
+// refresh requests a new version of the stale entry data from the origin,
+// conditionally when it has an ETag or a Last-Modified date, and stores the
+// result. A 304 refreshes the stored entry, which is returned. Any other
+// response replaces it and is returned buffered.
+func (m *cache) refresh(r *http.Request, key string, data *cacheData) (*cacheData, *bufferWriter) {
+	req := r.Clone(r.Context())
+
+	// The validators of the client are answered from the refreshed entry, a
+	// 304 of the origin must only describe the stored one.
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+
+	// The stored body is needed to refresh the entry on a 304, without it the
+	// request is sent unconditionally.
+	if err := data.load(); err != nil {
+		m.logger.Error("Error reading cache item", "key", key, "error", err)
+	} else {
+		if etag := data.Headers.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lm := data.Headers.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+
+	bw := &bufferWriter{header: make(http.Header)}
+	m.forward(bw, req)
+
+	if bw.status != http.StatusNotModified {
+		m.logger.Debug("Refresh replaced the stored response", "key", key, "status", bw.status)
+		m.store(key, r, bw.status, bw.header, bw.body.Bytes())
+		return nil, bw
+	}
+
+	m.logger.Debug("Refresh revalidated the stored response", "key", key)
+
+	for name, vals := range bw.header {
+		data.Headers[name] = vals
+	}
+
+	m.store(key, r, data.Status, data.Headers, data.Body)
+
+	return data, nil
+}
+
+// storeNotModified refreshes the entry stored for r with the headers h of a 304
+// of the origin, which answered the validators of the client, instead of
+// storing its empty body. An entry with another ETag is a different
+// representation and is left as is, see RFC 9111 section 4.3.4.
+func (m *cache) storeNotModified(key string, r *http.Request, h http.Header) {
+	data, err := m.lookup(key, r)
+	if err != nil {
+		m.logger.Debug("No stored response to refresh on 304", "key", key)
+		return
+	}
+	if err = data.load(); err != nil {
+		m.logger.Error("Error reading cache item", "key", key, "error", err)
+		return
+	}
+
+	if etag := h.Get("ETag"); etag != "" && !matchETag([]string{etag}, data.Headers.Get("ETag")) {
+		m.logger.Debug("304 does not match the stored response", "key", key, "etag", etag)
+		return
+	}
+
+	for name, vals := range h {
+		data.Headers[name] = vals
+	}
+
+	m.store(key, r, data.Status, data.Headers, data.Body)
+}
+
+// bufferWriter buffers a complete response so it can be inspected before
+// anything is sent to the client.
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferWriter) Write(p []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(p)
+}
+
+func (bw *bufferWriter) WriteHeader(s int) {
+	if bw.status == 0 {
+		bw.status = s
+	}
+}
+
+// writeTo sends the buffered response to w.
+func (bw *bufferWriter) writeTo(w http.ResponseWriter) {
+	for name, vals := range bw.header {
+		w.Header()[name] = vals
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	w.WriteHeader(bw.status)
+	_, _ = w.Write(bw.body.Bytes())
+}
@@ -0,0 +1,718 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTPRevalidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		etag       string
+		wantCode   int
+		wantBody   string
+		wantStatus string
+	}{
+		{
+			name:       "should refresh the entry on 304",
+			etag:       `"v1"`,
+			wantCode:   http.StatusNotModified,
+			wantBody:   `body "v1"`,
+			wantStatus: "hit",
+		},
+		{
+			name:       "should replace the entry on 200",
+			etag:       `"v2"`,
+			wantCode:   http.StatusOK,
+			wantBody:   `body "v2"`,
+			wantStatus: "miss",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			etag := `"v1"`
+
+			var (
+				calls       int
+				gotCodes    []int
+				ifNoneMatch string
+			)
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				ifNoneMatch = req.Header.Get("If-None-Match")
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.Header().Set("ETag", etag)
+				if ifNoneMatch == etag {
+					gotCodes = append(gotCodes, http.StatusNotModified)
+					rw.WriteHeader(http.StatusNotModified)
+					return
+				}
+				gotCodes = append(gotCodes, http.StatusOK)
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte("body " + etag))
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true, Revalidate: true}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			expireEntry(t, c, c.cacheKey(req))
+			etag = test.etag
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if ifNoneMatch != `"v1"` {
+				t.Errorf("unexpected If-None-Match: want %q, got %q", `"v1"`, ifNoneMatch)
+			}
+			if code := gotCodes[len(gotCodes)-1]; code != test.wantCode {
+				t.Errorf("unexpected origin status: want %d, got %d", test.wantCode, code)
+			}
+			if rw.Code != http.StatusOK {
+				t.Errorf("unexpected status code: want %d, got %d", http.StatusOK, rw.Code)
+			}
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected body: want %q, got %q", test.wantBody, body)
+			}
+			if state := rw.Header().Get("Cache-Status"); state != test.wantStatus {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantStatus, state)
+			}
+
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if calls != 2 {
+				t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+			}
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected body after revalidation: want %q, got %q", test.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPRevalidateLastModified(t *testing.T) {
+	lastModified := "Wed, 21 Oct 2015 07:28:00 GMT"
+
+	var (
+		calls           int
+		ifModifiedSince string
+	)
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		ifModifiedSince = req.Header.Get("If-Modified-Since")
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Last-Modified", lastModified)
+		if ifModifiedSince == lastModified {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, Revalidate: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	expireEntry(t, c, c.cacheKey(req))
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if calls != 2 || ifModifiedSince != lastModified {
+		t.Errorf("expected a conditional refresh, got %d calls with If-Modified-Since %q", calls, ifModifiedSince)
+	}
+	if rw.Code != http.StatusOK || rw.Body.String() != "body" {
+		t.Errorf("unexpected response: want %d %q, got %d %q", http.StatusOK, "body", rw.Code, rw.Body.String())
+	}
+	if want := "simplecache; fwd=stale; fwd-status=304; stored"; rw.Header().Get(cacheHeader) != want {
+		t.Errorf("unexpected cache status: want %q, got %q", want, rw.Header().Get(cacheHeader))
+	}
+}
+
+func TestCache_ServeHTTPStaleWhileRevalidate(t *testing.T) {
+	dir := createTempDir(t)
+
+	var version int32
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		v := atomic.AddInt32(&version, 1)
+		if v > 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(rw, "v%d", v)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true, StaleWhileRevalidate: 30}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	expireEntry(t, c, c.cacheKey(req))
+
+	start := time.Now()
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("expected stale response to be served without waiting for the origin, took %s", elapsed)
+	}
+	if body := rw.Body.String(); body != "v1" {
+		t.Errorf("unexpected stale body: want %q, got %q", "v1", body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := c.get(c.cacheKey(req))
+		if err == nil && string(data.Body) == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected entry to be refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if v := atomic.LoadInt32(&version); v != 2 {
+		t.Errorf("unexpected next handler calls: want 2, got %d", v)
+	}
+}
+
+// expireEntry marks the entry stored under key as stale.
+func TestCache_ServeHTTPConcurrentStaleHits(t *testing.T) {
+	release := make(chan struct{})
+
+	var version int32
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		v := atomic.AddInt32(&version, 1)
+		if v > 1 {
+			<-release
+		}
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = fmt.Fprintf(rw, "v%d", v)
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, StaleWhileRevalidate: 30}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	expireEntry(t, c, c.cacheKey(req))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if body := rw.Body.String(); body != "v1" {
+				t.Errorf("unexpected stale body: want %q, got %q", "v1", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := c.get(c.cacheKey(req))
+		if err == nil && string(data.Body) == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected entry to be refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if v := atomic.LoadInt32(&version); v != 2 {
+		t.Errorf("unexpected next handler calls: want 2, got %d", v)
+	}
+}
+
+func TestCache_CloseWaitsForRefresh(t *testing.T) {
+	release := make(chan struct{})
+
+	var version int32
+	var cancelled atomic.Value
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		v := atomic.AddInt32(&version, 1)
+		if v > 1 {
+			<-release
+			cancelled.Store(req.Context().Err() != nil)
+		}
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = fmt.Fprintf(rw, "v%d", v)
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, StaleWhileRevalidate: 30}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+	expireEntry(t, c, c.cacheKey(req))
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = c.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("expected Close to wait for the background refresh")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to return once the refresh is done")
+	}
+
+	if v, _ := cancelled.Load().(bool); !v {
+		t.Error("expected Close to cancel the refresh request")
+	}
+
+	// The response of the cancelled refresh may be incomplete, it is not stored.
+	data, err := c.get(c.cacheKey(req))
+	if err != nil || string(data.Body) != "v1" {
+		t.Errorf("expected the stale entry to be kept, got %v", err)
+	}
+}
+
+func TestCache_CloseCancelsRefresh(t *testing.T) {
+	var version int32
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&version, 1) > 1 {
+			<-req.Context().Done()
+			return
+		}
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("v1"))
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, StaleWhileRevalidate: 30}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+	expireEntry(t, c, c.cacheKey(req))
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = c.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to cancel the refresh waiting for the origin")
+	}
+}
+
+func TestCache_NoRefreshAfterClose(t *testing.T) {
+	var calls int32
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("v1"))
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, StaleWhileRevalidate: 30}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+	expireEntry(t, c, c.cacheKey(req))
+
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if rw.Body.String() != "v1" {
+		t.Errorf("unexpected body: want %q, got %q", "v1", rw.Body.String())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("unexpected next handler calls after Close: want 1, got %d", n)
+	}
+}
+
+func TestCache_ServeHTTPStaleOnError(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          Config
+		etag         string
+		cacheControl string
+		expiredFor   time.Duration
+		originCode   int
+		wantCode     int
+		wantBody     string
+		wantStatus   string
+	}{
+		{
+			name:       "should serve the stale entry on a bad gateway",
+			cfg:        Config{ServeStaleOnError: true},
+			expiredFor: time.Second,
+			originCode: http.StatusBadGateway,
+			wantCode:   http.StatusOK,
+			wantBody:   "v1",
+			wantStatus: "simplecache; fwd=stale; fwd-status=502; detail=stale-on-error",
+		},
+		{
+			name:       "should serve the stale entry when revalidation fails",
+			cfg:        Config{ServeStaleOnError: true, Revalidate: true},
+			etag:       `"v1"`,
+			expiredFor: time.Second,
+			originCode: http.StatusGatewayTimeout,
+			wantCode:   http.StatusOK,
+			wantBody:   "v1",
+			wantStatus: "simplecache; fwd=stale; fwd-status=504; detail=stale-on-error",
+		},
+		{
+			name:       "should serve the stale entry within the window",
+			cfg:        Config{ServeStaleOnError: true, MaxStaleOnError: 5},
+			expiredFor: 2 * time.Second,
+			originCode: http.StatusBadGateway,
+			wantCode:   http.StatusOK,
+			wantBody:   "v1",
+			wantStatus: "simplecache; fwd=stale; fwd-status=502; detail=stale-on-error",
+		},
+		{
+			name:       "should pass the error through outside the window",
+			cfg:        Config{ServeStaleOnError: true, MaxStaleOnError: 2},
+			expiredFor: 5 * time.Second,
+			originCode: http.StatusBadGateway,
+			wantCode:   http.StatusBadGateway,
+			wantBody:   "v2",
+			wantStatus: "simplecache; fwd=miss",
+		},
+		{
+			name:         "should not serve a must-revalidate entry stale",
+			cfg:          Config{ServeStaleOnError: true},
+			cacheControl: "max-age=20, must-revalidate",
+			expiredFor:   time.Second,
+			originCode:   http.StatusBadGateway,
+			wantCode:     http.StatusBadGateway,
+			wantBody:     "v2",
+			wantStatus:   "simplecache; fwd=miss",
+		},
+		{
+			name:       "should replace the stale entry when the origin recovers",
+			cfg:        Config{ServeStaleOnError: true},
+			expiredFor: time.Second,
+			originCode: http.StatusOK,
+			wantCode:   http.StatusOK,
+			wantBody:   "v2",
+			wantStatus: "simplecache; fwd=miss; stored",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				v := atomic.AddInt32(&calls, 1)
+				code := http.StatusOK
+				if v > 1 {
+					code = test.originCode
+				}
+				if test.etag != "" {
+					rw.Header().Set("ETag", test.etag)
+				}
+				if code == http.StatusOK {
+					cacheControl := test.cacheControl
+					if cacheControl == "" {
+						cacheControl = "max-age=20"
+					}
+					rw.Header().Set("Cache-Control", cacheControl)
+				}
+				rw.WriteHeader(code)
+				_, _ = fmt.Fprintf(rw, "v%d", v)
+			}
+
+			cfg := test.cfg
+			cfg.Path = createTempDir(t)
+			cfg.MaxExpiry = 10
+			cfg.Cleanup = 20
+			cfg.AddStatusHeader = true
+
+			h, err := New(context.Background(), http.HandlerFunc(next), &cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			key := c.cacheKey(req)
+			data, err := c.get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data.Expires = time.Now().Add(-test.expiredFor)
+			if err = c.set(key, *data, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+			}
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected body: want %q, got %q", test.wantBody, body)
+			}
+			if status := rw.Header().Get(cacheHeader); status != test.wantStatus {
+				t.Errorf("unexpected cache status: want %q, got %q", test.wantStatus, status)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPImmutable(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		wantCalls    int
+	}{
+		{
+			name:         "should not revalidate a fresh immutable entry",
+			cacheControl: "max-age=20, immutable",
+			wantCalls:    1,
+		},
+		{
+			name:         "should revalidate a fresh mutable entry",
+			cacheControl: "max-age=20",
+			wantCalls:    3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", test.cacheControl)
+				rw.Header().Set("ETag", `"v1"`)
+				if req.Header.Get("If-None-Match") == `"v1"` {
+					rw.WriteHeader(http.StatusNotModified)
+					return
+				}
+				_, _ = rw.Write([]byte("v1"))
+			}
+
+			cfg := &Config{
+				Path:                 createTempDir(t),
+				MaxExpiry:            10,
+				Cleanup:              20,
+				Revalidate:           true,
+				StaleWhileRevalidate: 30,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			clk := newFakeClock()
+			setClock(c, clk)
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			clk.Advance(5 * time.Second)
+
+			for _, header := range []string{"Cache-Control", "Pragma"} {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+				req.Header.Set(header, "no-cache")
+
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+
+				if body := rw.Body.String(); body != "v1" {
+					t.Errorf("unexpected body: want %q, got %q", "v1", body)
+				}
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPStoreNotModified(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantFresh   bool
+		wantVersion string
+	}{
+		{
+			name:        "should refresh the entry on a 304 for its etag",
+			ifNoneMatch: `"v1"`,
+			wantFresh:   true,
+			wantVersion: "2",
+		},
+		{
+			name:        "should leave the entry on a 304 for another etag",
+			ifNoneMatch: `"v0"`,
+			wantVersion: "1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version := 1
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "max-age=20")
+				rw.Header().Set("X-Version", strconv.Itoa(version))
+				if inm := req.Header.Get("If-None-Match"); inm != "" {
+					rw.Header().Set("ETag", inm)
+					rw.WriteHeader(http.StatusNotModified)
+					return
+				}
+				rw.Header().Set("ETag", `"v1"`)
+				_, _ = rw.Write([]byte("body"))
+			}
+
+			// Even a configured 304 must not replace the stored body.
+			cfg := &Config{
+				MaxExpiry:            100,
+				Cleanup:              200,
+				Backend:              memoryBackend,
+				CacheableStatusCodes: []int{http.StatusOK, http.StatusNotModified},
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			key := c.cacheKey(req)
+
+			c.ServeHTTP(httptest.NewRecorder(), req)
+			expireEntry(t, c, key)
+			version = 2
+
+			creq := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			creq.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, creq)
+
+			if rw.Code != http.StatusNotModified {
+				t.Errorf("unexpected status code: want %d, got %d", http.StatusNotModified, rw.Code)
+			}
+
+			data, err := c.get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.Status != http.StatusOK || string(data.Body) != "body" {
+				t.Errorf("unexpected stored response: status %d and body %q", data.Status, data.Body)
+			}
+			if fresh := c.fresh(data); fresh != test.wantFresh {
+				t.Errorf("unexpected freshness: want %t, got %t", test.wantFresh, fresh)
+			}
+			if v := data.Headers.Get("X-Version"); v != test.wantVersion {
+				t.Errorf("unexpected stored header: want %q, got %q", test.wantVersion, v)
+			}
+		})
+	}
+}
+
+func expireEntry(tb testing.TB, c *cache, key string) {
+	tb.Helper()
+
+	data, err := c.get(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	data.Expires = time.Now().Add(-time.Second)
+
+	if err = c.set(key, *data, time.Minute); err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// shadow forwards the request to the next handler, counting and logging whether
+// a fresh stored response would have been served instead. Responses are stored
+// on misses, but stored bodies are never served.
+func (m *cache) shadow(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := m.lookup(key, r)
+	hit := err == nil && m.fresh(data)
+	data.close()
+
+	// The stored response is kept as is on a hit, so it expires when it would
+	// have without the shadow mode.
+	if hit {
+		atomic.AddUint64(&m.stats.hits, 1)
+		m.logger.Debug("Shadow cache hit", "key", key)
+		m.setCacheStatus(w.Header(), cacheStatus{fwd: fwdBypass, detail: detailShadowHit})
+		m.forward(w, r)
+		return
+	}
+
+	atomic.AddUint64(&m.stats.misses, 1)
+	m.logger.Debug("Shadow cache miss", "key", key)
+	m.fetch(w, r, key, cacheStatus{fwd: fwdBypass, detail: detailShadowMiss})
+}
@@ -0,0 +1,98 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTPShadowMode(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("origin body"))
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true, Backend: memoryBackend, ShadowMode: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	clk := newFakeClock()
+	setClock(c, clk)
+
+	wantStatuses := []string{
+		"simplecache; fwd=bypass; stored; detail=shadow-miss",
+		"simplecache; fwd=bypass; detail=shadow-hit",
+		"simplecache; fwd=bypass; detail=shadow-hit",
+		"simplecache; fwd=bypass; stored; detail=shadow-miss",
+	}
+
+	for i, want := range wantStatuses {
+		if i == 3 {
+			clk.Advance(20 * time.Second)
+		}
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if got := rw.Header().Get(cacheHeader); got != want {
+			t.Errorf("unexpected cache status of request %d: want %q, got %q", i, want, got)
+		}
+		if rw.Body.String() != "origin body" {
+			t.Errorf("unexpected body of request %d: got %q", i, rw.Body.String())
+		}
+	}
+
+	if calls != len(wantStatuses) {
+		t.Errorf("expected the origin to be contacted for every request, got %d next handler calls", calls)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("unexpected counters: want 2 hits and 2 misses, got %d hits and %d misses", stats.Hits, stats.Misses)
+	}
+}
+
+func TestCache_ServeHTTPShadowModeOriginTimeout(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls > 1 {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-time.After(2 * time.Second):
+			}
+		}
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("origin body"))
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true, Backend: memoryBackend, ShadowMode: true, OriginTimeout: 1}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	// A shadow hit is forwarded like a miss, so the origin timeout applies.
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	if rw.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code of a shadow hit: want %d, got %d", http.StatusGatewayTimeout, rw.Code)
+	}
+	if want := "simplecache; fwd=bypass; detail=shadow-hit"; rw.Header().Get(cacheHeader) != want {
+		t.Errorf("unexpected cache status: want %q, got %q", want, rw.Header().Get(cacheHeader))
+	}
+}
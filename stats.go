@@ -0,0 +1,135 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsFileName is the file the counters are persisted to under the path of
+// the file backend.
+const statsFileName = "stats.json"
+
+// statsPersistInterval is how often the counters are persisted.
+const statsPersistInterval = time.Minute
+
+// cacheStats holds the cache counters. It must only be accessed atomically.
+type cacheStats struct {
+	hits   uint64
+	misses uint64
+	errors uint64
+	sets   uint64
+
+	// evictions were counted before a restart, the backend counts the others.
+	evictions uint64
+}
+
+// statsSnapshot is a point in time copy of the cache counters.
+type statsSnapshot struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Errors    uint64 `json:"errors"`
+	Sets      uint64 `json:"sets"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// Stats returns a snapshot of the cache counters.
+func (m *cache) Stats() statsSnapshot {
+	return statsSnapshot{
+		Hits:      atomic.LoadUint64(&m.stats.hits),
+		Misses:    atomic.LoadUint64(&m.stats.misses),
+		Errors:    atomic.LoadUint64(&m.stats.errors),
+		Sets:      atomic.LoadUint64(&m.stats.sets),
+		Evictions: atomic.LoadUint64(&m.stats.evictions) + m.cache.Evictions(),
+	}
+}
+
+var (
+	publishedMu sync.Mutex
+	published   = make(map[string]*cache)
+)
+
+// publishStats exposes the counters of m through expvar. Traefik creates a new
+// instance on every configuration reload, so the variable is only published
+// once per name and always reports the most recent instance.
+func publishStats(m *cache) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+
+	name := "simplecache." + m.name
+
+	if _, ok := published[name]; !ok {
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			publishedMu.Lock()
+			c := published[name]
+			publishedMu.Unlock()
+
+			return c.Stats()
+		}))
+	}
+
+	published[name] = m
+}
+
+// loadStats returns the counters persisted at path. A missing or corrupted
+// file starts the counters from zero.
+func loadStats(path string) (*cacheStats, error) {
+	b, err := ioutil.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return &cacheStats{}, nil
+	}
+	if err != nil {
+		return &cacheStats{}, err
+	}
+
+	var snap statsSnapshot
+	if err = json.Unmarshal(b, &snap); err != nil {
+		return &cacheStats{}, err
+	}
+
+	return &cacheStats{
+		hits:      snap.Hits,
+		misses:    snap.Misses,
+		errors:    snap.Errors,
+		sets:      snap.Sets,
+		evictions: snap.Evictions,
+	}, nil
+}
+
+// saveStats persists the counters to path.
+func (m *cache) saveStats(path string, mode os.FileMode) error {
+	b, err := json.Marshal(m.Stats())
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b, mode)
+}
+
+// persistStats persists the counters to path every interval, and a last time
+// when ctx is done.
+func (m *cache) persistStats(ctx context.Context, path string, mode os.FileMode, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.saveStats(path, mode); err != nil {
+				m.logger.Error("Error persisting statistics", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := m.saveStats(path, mode); err != nil {
+				m.logger.Error("Error persisting statistics", "error", err)
+			}
+		}
+	}
+}
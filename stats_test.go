@@ -0,0 +1,114 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCache_Stats(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "stats-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	for _, path := range []string{"/a", "/b", "/a", "/a", "/b"} {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+	}
+
+	want := statsSnapshot{Hits: 3, Misses: 2, Sets: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("unexpected stats: want %+v, got %+v", want, got)
+	}
+
+	v := expvar.Get("simplecache.stats-test")
+	if v == nil {
+		t.Fatal("expected stats to be published")
+	}
+
+	var got statsSnapshot
+	if err = json.Unmarshal([]byte(v.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("unexpected published stats: want %+v, got %+v", want, got)
+	}
+
+	// Creating another instance with the same name must not panic.
+	if _, err = New(context.Background(), http.HandlerFunc(next), cfg, "stats-test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCache_PersistStats(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	// Storing /b evicts /a.
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, PersistStats: true, MaxEntries: 1}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "persist-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	for _, path := range []string{"/a", "/a", "/a", "/b"} {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+	}
+
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = New(context.Background(), http.HandlerFunc(next), cfg, "persist-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = h.(*cache)
+	t.Cleanup(func() { _ = c.Close() })
+
+	want := statsSnapshot{Hits: 2, Misses: 2, Sets: 2, Evictions: 1}
+	if got := c.Stats(); got != want {
+		t.Errorf("unexpected restored stats: want %+v, got %+v", want, got)
+	}
+}
+
+func TestLoadStats(t *testing.T) {
+	dir := createTempDir(t)
+
+	path := filepath.Join(dir, statsFileName)
+
+	stats, err := loadStats(path)
+	if err != nil || *stats != (cacheStats{}) {
+		t.Errorf("expected a missing file to start from zero, got %+v: %v", stats, err)
+	}
+
+	if err = ioutil.WriteFile(path, []byte(`{"hits": 3`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = loadStats(path)
+	if err == nil || *stats != (cacheStats{}) {
+		t.Errorf("expected a corrupted file to start from zero with an error, got %+v: %v", stats, err)
+	}
+}
@@ -0,0 +1,143 @@
+package plugin_simplecache
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Forward reasons of the Cache-Status header, see RFC 9211.
+const (
+	fwdMiss    = "miss"
+	fwdRequest = "request"
+	fwdStale   = "stale"
+	fwdBypass  = "bypass"
+)
+
+// Details of the Cache-Status header.
+const (
+	// detailStaleOnError marks a stale response served because the origin failed.
+	detailStaleOnError = "stale-on-error"
+	// detailShadowHit and detailShadowMiss mark the responses of the shadow
+	// mode, which would have been served from the cache or not.
+	detailShadowHit  = "shadow-hit"
+	detailShadowMiss = "shadow-miss"
+	// detailWriteOnly marks the responses of the write only mode.
+	detailWriteOnly = "write-only"
+)
+
+// cacheStatus describes how the cache handled a request, reported through the
+// Cache-Status response header.
+type cacheStatus struct {
+	hit       bool
+	fwd       string
+	fwdStatus int
+	ttl       *time.Duration
+	stored    bool
+	detail    string
+}
+
+// hitStatus returns the status of a response served from data.
+func (m *cache) hitStatus(data *cacheData) cacheStatus {
+	cs := cacheStatus{hit: true}
+	if !data.Expires.IsZero() {
+		ttl := data.Expires.Sub(m.clock.Now())
+		cs.ttl = &ttl
+	}
+
+	return cs
+}
+
+// legacy returns the plain hit, miss, error or bypass value of the status.
+func (cs cacheStatus) legacy() string {
+	switch {
+	case cs.detail == cacheErrorStatus:
+		return cacheErrorStatus
+	case cs.fwd == fwdBypass:
+		return cacheBypassStatus
+	case cs.hit, cs.fwd == fwdStale && cs.fwdStatus == http.StatusNotModified, cs.detail == detailStaleOnError:
+		return cacheHitStatus
+	default:
+		return cacheMissStatus
+	}
+}
+
+// format returns the RFC 9211 structured value of the status for the cache
+// called name.
+func (cs cacheStatus) format(name string) string {
+	params := []string{sfItem(name)}
+
+	if cs.hit {
+		params = append(params, "hit")
+	} else {
+		params = append(params, "fwd="+cs.fwd)
+	}
+	if cs.fwdStatus != 0 {
+		params = append(params, "fwd-status="+strconv.Itoa(cs.fwdStatus))
+	}
+	if cs.ttl != nil {
+		params = append(params, "ttl="+strconv.Itoa(int(math.Round(cs.ttl.Seconds()))))
+	}
+	if cs.stored {
+		params = append(params, "stored")
+	}
+	if cs.detail != "" {
+		params = append(params, "detail="+sfItem(cs.detail))
+	}
+
+	return strings.Join(params, "; ")
+}
+
+// setCacheStatus sets the status header on h if enabled, Cache-Status unless
+// another name is configured.
+func (m *cache) setCacheStatus(h http.Header, cs cacheStatus) {
+	if !m.cfg.AddStatusHeader {
+		return
+	}
+
+	name := m.cfg.StatusHeaderName
+	if name == "" {
+		name = cacheHeader
+	}
+
+	switch {
+	case m.cfg.UppercaseStatusHeader:
+		h.Set(name, strings.ToUpper(cs.legacy()))
+	case m.cfg.LegacyStatusHeader:
+		h.Set(name, cs.legacy())
+	default:
+		h.Set(name, cs.format(m.name))
+	}
+}
+
+// sfItem returns s as a structured field token if it is a valid one, and as a
+// structured field string otherwise.
+func sfItem(s string) string {
+	if isSFToken(s) {
+		return s
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func isSFToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '*':
+		case i == 0:
+			return false
+		case c >= '0' && c <= '9', c == ':', c == '/', strings.ContainsRune("!#$%&'+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
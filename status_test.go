@@ -0,0 +1,147 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCache_ServeHTTPCacheStatus(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/private" {
+			rw.Header().Set("Cache-Control", "no-store")
+		} else {
+			rw.Header().Set("Cache-Control", "max-age=120")
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 300, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "my-cache@file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantParams map[string]string
+	}{
+		{
+			name:       "should report a miss that is not stored",
+			path:       "/private",
+			wantParams: map[string]string{"fwd": "miss"},
+		},
+		{
+			name:       "should report a stored miss",
+			path:       "/public",
+			wantParams: map[string]string{"fwd": "miss", "stored": ""},
+		},
+		{
+			name:       "should report a hit with its remaining ttl",
+			path:       "/public",
+			wantParams: map[string]string{"hit": "", "ttl": "120"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost"+test.path, nil))
+
+			name, params := parseCacheStatus(t, rw.Header().Get("Cache-Status"))
+			if name != `"my-cache@file"` {
+				t.Errorf("unexpected cache name: want %q, got %q", `"my-cache@file"`, name)
+			}
+			if len(params) != len(test.wantParams) {
+				t.Errorf("unexpected parameters: want %v, got %v", test.wantParams, params)
+			}
+			for key, want := range test.wantParams {
+				if got, ok := params[key]; !ok || got != want {
+					t.Errorf("unexpected parameter %q: want %q, got %q", key, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPStatusHeaderName(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=120")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Path:                  createTempDir(t),
+		MaxExpiry:             300,
+		Cleanup:               20,
+		AddStatusHeader:       true,
+		StatusHeaderName:      "X-Cache",
+		UppercaseStatusHeader: true,
+	}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"MISS", "HIT"} {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if got := rw.Header().Get("X-Cache"); got != want {
+			t.Errorf("unexpected X-Cache header: want %q, got %q", want, got)
+		}
+		if got := rw.Header().Get(cacheHeader); got != "" {
+			t.Errorf("unexpected %s header: %q", cacheHeader, got)
+		}
+	}
+}
+
+func TestCacheStatus_Legacy(t *testing.T) {
+	tests := []struct {
+		cs   cacheStatus
+		want string
+	}{
+		{cs: cacheStatus{hit: true}, want: "hit"},
+		{cs: cacheStatus{fwd: fwdMiss, stored: true}, want: "miss"},
+		{cs: cacheStatus{fwd: fwdMiss, detail: "error"}, want: "error"},
+		{cs: cacheStatus{fwd: fwdStale, fwdStatus: http.StatusNotModified}, want: "hit"},
+		{cs: cacheStatus{fwd: fwdStale, fwdStatus: http.StatusOK}, want: "miss"},
+		{cs: cacheStatus{fwd: fwdBypass}, want: "bypass"},
+	}
+
+	for _, test := range tests {
+		if got := test.cs.legacy(); got != test.want {
+			t.Errorf("unexpected legacy status for %+v: want %q, got %q", test.cs, test.want, got)
+		}
+	}
+}
+
+// parseCacheStatus splits a single Cache-Status entry into its cache name and
+// parameters.
+func parseCacheStatus(tb testing.TB, v string) (string, map[string]string) {
+	tb.Helper()
+
+	parts := strings.Split(v, ";")
+	if len(parts) < 2 {
+		tb.Fatalf("invalid Cache-Status header: %q", v)
+	}
+
+	params := make(map[string]string)
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 1 {
+			params[kv[0]] = ""
+			continue
+		}
+		params[kv[0]] = kv[1]
+	}
+
+	return strings.TrimSpace(parts[0]), params
+}
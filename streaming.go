@@ -0,0 +1,37 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// streamed reports whether h are the headers of a streamed response, such as
+// Server-Sent Events or a chunked response of unknown length. Such responses
+// are passed through without being buffered, as they may never complete.
+func streamed(h http.Header) bool {
+	if mediaType(h.Get("Content-Type")) == "text/event-stream" {
+		return true
+	}
+
+	return h.Get("Content-Length") == "" && hasToken(h, "Transfer-Encoding", "chunked")
+}
+
+// upgradeRequest reports whether r asks to upgrade its connection to another
+// protocol, such as a WebSocket. Such requests are never cached.
+func upgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && hasToken(r.Header, "Connection", "upgrade")
+}
+
+// hasToken reports whether the comma separated list header name of h contains
+// token, ignoring case.
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
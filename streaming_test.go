@@ -0,0 +1,221 @@
+package plugin_simplecache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCache_ServeHTTPStreamed(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		flush  bool
+	}{
+		{
+			name:   "should not cache server-sent events",
+			header: http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}},
+		},
+		{
+			name:   "should not cache a chunked response of unknown length",
+			header: http.Header{"Transfer-Encoding": {"chunked"}},
+		},
+		{
+			name:  "should not cache a flushed response",
+			flush: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				for k, v := range test.header {
+					rw.Header()[k] = v
+				}
+				rw.Header().Set("Cache-Control", "max-age=20")
+
+				if test.flush {
+					rw.(http.Flusher).Flush()
+				}
+				for i := 0; i < 3; i++ {
+					_, _ = fmt.Fprintf(rw, "data: %d\n\n", i)
+				}
+			}
+
+			cfg := &Config{MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true, Backend: memoryBackend}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rw *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/events", nil))
+			}
+
+			if calls != 2 {
+				t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+			}
+			if want := "data: 0\n\ndata: 1\n\ndata: 2\n\n"; rw.Body.String() != want {
+				t.Errorf("unexpected body: want %q, got %q", want, rw.Body.String())
+			}
+			if test.flush && !rw.Flushed {
+				t.Error("expected the flush to reach the client")
+			}
+			if want := "simplecache; fwd=miss"; rw.Header().Get(cacheHeader) != want {
+				t.Errorf("unexpected cache status: want %q, got %q", want, rw.Header().Get(cacheHeader))
+			}
+		})
+	}
+}
+
+func TestResponseWriter_StreamedNotBuffered(t *testing.T) {
+	rw := &responseWriter{ResponseWriter: httptest.NewRecorder(), body: getBuffer()}
+	defer putBuffer(rw.body)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	_, _ = rw.Write([]byte("data: 0\n\n"))
+
+	if !rw.streaming {
+		t.Error("expected the response to be detected as streamed")
+	}
+	if rw.body.Len() != 0 {
+		t.Errorf("unexpected buffered body: %q", rw.body.String())
+	}
+}
+
+func TestCache_ServeHTTPHijacked(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+
+		conn, brw, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("unexpected hijack error: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, _ = brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		_ = brw.Flush()
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: memoryBackend}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/socket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(body) != "hijacked" {
+			t.Errorf("unexpected body: want %q, got %q", "hijacked", body)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+	}
+}
+
+func TestResponseWriter_ReadFrom(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: rec, body: getBuffer()}
+	defer putBuffer(rw.body)
+
+	n, err := rw.ReadFrom(strings.NewReader("some content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 12 || rec.Body.String() != "some content" {
+		t.Errorf("unexpected copy: %d bytes, body %q", n, rec.Body.String())
+	}
+	if rw.body.String() != "some content" {
+		t.Errorf("unexpected buffered body: %q", rw.body.String())
+	}
+	if rw.status != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, rw.status)
+	}
+}
+
+func TestCache_ServeHTTPUpgrade(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if _, ok := rw.(*responseWriter); ok {
+			t.Error("expected the writer of an upgrade not to be wrapped")
+		}
+
+		conn, brw, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("unexpected hijack error: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = brw.Flush()
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, AddStatusHeader: true, Backend: memoryBackend}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = io.WriteString(conn, "GET /socket HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	if got := resp.Header.Get(cacheHeader); got != "" {
+		t.Errorf("unexpected cache status: %q", got)
+	}
+	if stats := c.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("expected the cache not to be looked up, got %+v", stats)
+	}
+}
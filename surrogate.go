@@ -0,0 +1,50 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const surrogateControlHeader = "Surrogate-Control"
+
+// surrogateControl returns the storage TTL set by the Surrogate-Control header
+// of h, see the Edge Architecture Specification. A no-store directive returns a
+// zero TTL. Directives targeted at a specific surrogate are ignored. It reports
+// false when the header sets neither.
+func surrogateControl(h http.Header) (time.Duration, bool) {
+	var (
+		ttl   time.Duration
+		found bool
+	)
+
+	for _, v := range h.Values(surrogateControlHeader) {
+		for _, dir := range strings.Split(v, ",") {
+			dir = strings.ToLower(strings.TrimSpace(dir))
+			if strings.Contains(dir, ";") {
+				continue
+			}
+
+			switch {
+			case dir == "no-store":
+				return 0, true
+			case strings.HasPrefix(dir, "max-age="):
+				secs, err := strconv.Atoi(strings.TrimPrefix(dir, "max-age="))
+				if err == nil && secs >= 0 {
+					ttl, found = time.Duration(secs)*time.Second, true
+				}
+			}
+		}
+	}
+
+	return ttl, found
+}
+
+// dropSurrogateControl removes the Surrogate-Control header from h when it is
+// honored, as it is only meant for the cache.
+func (m *cache) dropSurrogateControl(h http.Header) {
+	if m.cfg.HonorSurrogateControl {
+		h.Del(surrogateControlHeader)
+	}
+}
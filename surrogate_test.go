@@ -0,0 +1,101 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTPSurrogateControl(t *testing.T) {
+	tests := []struct {
+		name       string
+		honor      bool
+		surrogate  string
+		wantCalls  int
+		wantStatus string
+	}{
+		{
+			name:       "should store for the surrogate max-age",
+			honor:      true,
+			surrogate:  "max-age=60",
+			wantCalls:  1,
+			wantStatus: "simplecache; hit; ttl=50",
+		},
+		{
+			name:       "should not store with a surrogate no-store",
+			honor:      true,
+			surrogate:  "no-store",
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=miss",
+		},
+		{
+			name:       "should ignore targeted surrogate directives",
+			honor:      true,
+			surrogate:  "max-age=60;edge",
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=miss; stored",
+		},
+		{
+			name:       "should use Cache-Control unless honored",
+			surrogate:  "max-age=60",
+			wantCalls:  2,
+			wantStatus: "simplecache; fwd=miss; stored",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=5")
+				rw.Header().Set("Surrogate-Control", test.surrogate)
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				MaxExpiry:             100,
+				Cleanup:               200,
+				AddStatusHeader:       true,
+				Backend:               memoryBackend,
+				HonorSurrogateControl: test.honor,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			clk := newFakeClock()
+			setClock(c, clk)
+
+			var rws []*httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+				rws = append(rws, rw)
+
+				clk.Advance(10 * time.Second)
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("unexpected next handler calls: want %d, got %d", test.wantCalls, calls)
+			}
+			if got := rws[1].Header().Get(cacheHeader); got != test.wantStatus {
+				t.Errorf("unexpected cache status: want %q, got %q", test.wantStatus, got)
+			}
+
+			for _, rw := range rws {
+				if got := rw.Header().Get("Cache-Control"); got != "max-age=5" {
+					t.Errorf("unexpected Cache-Control header: want %q, got %q", "max-age=5", got)
+				}
+				if got := rw.Header().Get("Surrogate-Control") != ""; got != !test.honor {
+					t.Errorf("unexpected Surrogate-Control header: want sent %t, got %q", !test.honor, rw.Header().Get("Surrogate-Control"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package plugin_simplecache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// forward sends the request to the next handler. With an origin timeout, a
+// handler that has not started its response in time gets its request context
+// cancelled and the client a 504. Writes of the handler after the timeout are
+// discarded, so it may keep running until it notices the cancellation.
+func (m *cache) forward(w http.ResponseWriter, r *http.Request) {
+	if m.cfg.OriginTimeout <= 0 {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	tw := &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+	done := make(chan struct{})
+	panics := make(chan interface{}, 1)
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panics <- p
+			}
+			close(done)
+		}()
+		m.next.ServeHTTP(tw, r.WithContext(ctx))
+	}()
+
+	timer := time.NewTimer(time.Duration(m.cfg.OriginTimeout) * time.Second)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		if tw.timeout() {
+			m.logger.Warn("Origin timed out", "path", r.URL.Path, "timeout", m.cfg.OriginTimeout)
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		// The response has started, the handler is left to complete it.
+		<-done
+	}
+
+	select {
+	case p := <-panics:
+		panic(p)
+	default:
+	}
+
+	// A handler returning without writing sends an empty 200, with the headers
+	// it set.
+	if !tw.started {
+		tw.WriteHeader(http.StatusOK)
+	}
+}
+
+// timeoutWriter passes a response through to the ResponseWriter until it times
+// out, which only happens while the response has not started. The headers are
+// kept apart until then, as the handler may still set them after a timeout.
+type timeoutWriter struct {
+	http.ResponseWriter
+
+	header http.Header
+
+	mu       sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+// timeout marks the response as timed out, unless it has started.
+func (tw *timeoutWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.timedOut = !tw.started
+	return tw.timedOut
+}
+
+// start marks the response as started, unless it has timed out.
+func (tw *timeoutWriter) start() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.started || tw.timedOut {
+		return tw.started
+	}
+	tw.started = true
+
+	h := tw.ResponseWriter.Header()
+	for name := range h {
+		if _, ok := tw.header[name]; !ok {
+			delete(h, name)
+		}
+	}
+	for name, vals := range tw.header {
+		h[name] = vals
+	}
+
+	return true
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.started {
+		return tw.ResponseWriter.Header()
+	}
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	if !tw.start() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(s int) {
+	if tw.start() {
+		tw.ResponseWriter.WriteHeader(s)
+	}
+}
+
+func (tw *timeoutWriter) Flush() {
+	if !tw.start() {
+		return
+	}
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if !tw.start() {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+
+	hj, ok := tw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackUnsupported
+	}
+
+	return hj.Hijack()
+}
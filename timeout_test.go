@@ -0,0 +1,175 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTPOriginTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		started    bool
+		wantCode   int
+		wantBody   string
+		wantStatus string
+	}{
+		{
+			name:       "should return a gateway timeout",
+			wantCode:   http.StatusGatewayTimeout,
+			wantBody:   "",
+			wantStatus: "simplecache; fwd=miss",
+		},
+		{
+			name:       "should serve the stale entry on a timeout",
+			cfg:        Config{ServeStaleOnError: true},
+			wantCode:   http.StatusOK,
+			wantBody:   "v1",
+			wantStatus: "simplecache; fwd=stale; fwd-status=504; detail=stale-on-error",
+		},
+		{
+			name:       "should not time out a started response",
+			started:    true,
+			wantCode:   http.StatusOK,
+			wantBody:   "v2",
+			wantStatus: "simplecache; fwd=miss; stored",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			exited := make(chan error, 1)
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				v := atomic.AddInt32(&calls, 1)
+				rw.Header().Set("Cache-Control", "max-age=20")
+
+				if v > 1 && test.started {
+					rw.WriteHeader(http.StatusOK)
+					time.Sleep(1200 * time.Millisecond)
+				}
+				if v > 1 && !test.started {
+					<-req.Context().Done()
+					_, err := fmt.Fprintf(rw, "v%d", v)
+					exited <- err
+					return
+				}
+
+				_, _ = fmt.Fprintf(rw, "v%d", v)
+			}
+
+			cfg := test.cfg
+			cfg.MaxExpiry = 10
+			cfg.Cleanup = 20
+			cfg.Backend = memoryBackend
+			cfg.AddStatusHeader = true
+			cfg.OriginTimeout = 1
+
+			h, err := New(context.Background(), http.HandlerFunc(next), &cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+			c.ServeHTTP(httptest.NewRecorder(), req)
+			expireEntry(t, c, c.cacheKey(req))
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status code: want %d, got %d", test.wantCode, rw.Code)
+			}
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected body: want %q, got %q", test.wantBody, body)
+			}
+			if status := rw.Header().Get(cacheHeader); status != test.wantStatus {
+				t.Errorf("unexpected cache status: want %q, got %q", test.wantStatus, status)
+			}
+
+			if test.started {
+				return
+			}
+
+			select {
+			case err = <-exited:
+				if err != http.ErrHandlerTimeout {
+					t.Errorf("unexpected write error after the timeout: want %v, got %v", http.ErrHandlerTimeout, err)
+				}
+			case <-time.After(time.Second):
+				t.Error("expected the request context of the next handler to be cancelled")
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPOriginTimeoutImplicitStatus(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("X-Foo", "foo")
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, AddStatusHeader: true, OriginTimeout: 1}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("unexpected status code: want %d, got %d", http.StatusOK, rw.Code)
+	}
+	for name, want := range map[string]string{"X-Foo": "foo", cacheHeader: "simplecache; fwd=miss; stored"} {
+		if got := rw.Header().Get(name); got != want {
+			t.Errorf("unexpected %s of the response: want %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestCache_WarmupOriginTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		close(cancelled)
+	}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, Backend: memoryBackend, OriginTimeout: 1}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	done := make(chan struct{})
+	go func() {
+		c.warmup(context.Background(), []string{"http://localhost/slow"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the warmup to time out")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("expected the request context of the next handler to be cancelled")
+	}
+
+	if entries, _ := c.cache.(sizer).usage(); entries != 0 {
+		t.Errorf("unexpected stored entries: want 0, got %d", entries)
+	}
+}
@@ -0,0 +1,128 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bodyTransform rewrites the body of a response with headers h before it is
+// stored. It returns false when it does not apply to the response, or fails,
+// and must not modify body, which may still be sent to the client.
+type bodyTransform func(h http.Header, body []byte) ([]byte, bool)
+
+// bodyTransforms are the transforms that can be configured, by their name in
+// lower case.
+var bodyTransforms = map[string]bodyTransform{
+	"stripcomments": stripComments,
+	"minifyjson":    minifyJSON,
+}
+
+// transformsOf returns the transforms called names, in order. Unknown names are
+// rejected by the validation of the configuration.
+func transformsOf(names []string) []bodyTransform {
+	var transforms []bodyTransform
+	for _, name := range names {
+		if t, ok := bodyTransforms[strings.ToLower(name)]; ok {
+			transforms = append(transforms, t)
+		}
+	}
+
+	return transforms
+}
+
+// transform applies the configured transforms to the response body with
+// headers h, reporting whether any changed it. Bodies with a content coding
+// are stored as is.
+func (m *cache) transform(h http.Header, body []byte) ([]byte, bool) {
+	if len(m.rewrites) == 0 || h.Get("Content-Encoding") != "" {
+		return body, false
+	}
+
+	transformed := false
+	for _, t := range m.rewrites {
+		if b, ok := t(h, body); ok {
+			body = b
+			transformed = true
+		}
+	}
+
+	return body, transformed
+}
+
+// transformedHeaders updates the stored headers h for a transformed body of n
+// bytes, which is only weakly equivalent to the one sent by the origin.
+func transformedHeaders(h http.Header, n int) {
+	if h.Get("Content-Length") != "" {
+		h.Set("Content-Length", strconv.Itoa(n))
+	}
+	weakenETag(h)
+}
+
+// weakenETag turns the strong ETag of h, if any, into a weak one.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// minifyJSON removes the insignificant whitespace of JSON bodies.
+func minifyJSON(h http.Header, body []byte) ([]byte, bool) {
+	mt := mediaType(h.Get("Content-Type"))
+	if mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		return nil, false
+	}
+
+	var b bytes.Buffer
+	if err := json.Compact(&b, body); err != nil {
+		return nil, false
+	}
+
+	return b.Bytes(), true
+}
+
+var (
+	commentStart = []byte("<!--")
+	commentEnd   = []byte("-->")
+)
+
+// stripComments removes the comments of HTML bodies. Conditional comments are
+// kept, as some browsers read them as markup.
+func stripComments(h http.Header, body []byte) ([]byte, bool) {
+	if mediaType(h.Get("Content-Type")) != "text/html" {
+		return nil, false
+	}
+
+	var b bytes.Buffer
+	stripped := false
+
+	rest := body
+	for {
+		i := bytes.Index(rest, commentStart)
+		if i < 0 {
+			break
+		}
+		j := bytes.Index(rest[i+len(commentStart):], commentEnd)
+		if j < 0 {
+			break
+		}
+		end := i + len(commentStart) + j + len(commentEnd)
+
+		if bytes.HasPrefix(rest[i+len(commentStart):], []byte("[if")) {
+			b.Write(rest[:end])
+		} else {
+			b.Write(rest[:i])
+			stripped = true
+		}
+		rest = rest[end:]
+	}
+
+	if !stripped {
+		return nil, false
+	}
+	b.Write(rest)
+
+	return b.Bytes(), true
+}
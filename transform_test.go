@@ -0,0 +1,132 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+		wantOK      bool
+	}{
+		{
+			contentType: "text/html; charset=utf-8",
+			body:        "<p>a</p><!-- built at 12:00 --><p>b</p><!---->",
+			want:        "<p>a</p><p>b</p>",
+			wantOK:      true,
+		},
+		{
+			contentType: "text/html",
+			body:        "<!--[if IE]><p>ie</p><![endif]--><!-- x --><p>b</p>",
+			want:        "<!--[if IE]><p>ie</p><![endif]--><p>b</p>",
+			wantOK:      true,
+		},
+		{contentType: "text/html", body: "<p>a</p><!-- unterminated"},
+		{contentType: "text/plain", body: "<!-- x -->"},
+	}
+
+	for _, test := range tests {
+		h := http.Header{"Content-Type": {test.contentType}}
+
+		got, ok := stripComments(h, []byte(test.body))
+		if ok != test.wantOK || string(got) != test.want {
+			t.Errorf("unexpected result for %q: want %q and %t, got %q and %t", test.body, test.want, test.wantOK, got, ok)
+		}
+	}
+}
+
+func TestMinifyJSON(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+		wantOK      bool
+	}{
+		{
+			contentType: "application/json",
+			body:        "{\n  \"a\": [1, 2],\n  \"b\": \"x y\"\n}\n",
+			want:        `{"a":[1,2],"b":"x y"}`,
+			wantOK:      true,
+		},
+		{contentType: "application/problem+json", body: `{ "a": 1 }`, want: `{"a":1}`, wantOK: true},
+		{contentType: "application/json", body: `{"a": `},
+		{contentType: "text/plain", body: `{ "a": 1 }`},
+	}
+
+	for _, test := range tests {
+		h := http.Header{"Content-Type": {test.contentType}}
+
+		got, ok := minifyJSON(h, []byte(test.body))
+		if ok != test.wantOK || string(got) != test.want {
+			t.Errorf("unexpected result for %q: want %q and %t, got %q and %t", test.body, test.want, test.wantOK, got, ok)
+		}
+	}
+}
+
+func TestCache_ServeHTTPBodyTransforms(t *testing.T) {
+	body := "{\n  \"id\": 1,\n  \"name\": \"some name\"\n}\n"
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		rw.Header().Set("ETag", `"v1"`)
+		_, _ = rw.Write([]byte(body))
+	}
+
+	cfg := &Config{
+		MaxExpiry:      100,
+		Cleanup:        200,
+		Backend:        memoryBackend,
+		BodyTransforms: []string{"stripComments", "MinifyJSON"},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if rw.Body.String() != body {
+		t.Errorf("unexpected live body: want %q, got %q", body, rw.Body.String())
+	}
+
+	data, err := c.get(c.cacheKey(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"id":1,"name":"some name"}`
+	if string(data.Body) != want {
+		t.Errorf("unexpected stored body: want %q, got %q", want, data.Body)
+	}
+	if cl := data.Headers.Get("Content-Length"); cl != "27" {
+		t.Errorf("unexpected stored content length: want %q, got %q", "27", cl)
+	}
+	if etag := data.Headers.Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("unexpected stored etag: want %q, got %q", `W/"v1"`, etag)
+	}
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if rw.Body.String() != want {
+		t.Errorf("unexpected cached body: want %q, got %q", want, rw.Body.String())
+	}
+	if calls != 1 {
+		t.Errorf("unexpected next handler calls: want 1, got %d", calls)
+	}
+}
@@ -0,0 +1,94 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// varyHeaders returns the sorted, canonical request header names listed in the
+// Vary response header. It returns false if the response varies on "*" and can
+// therefore never be served from the cache.
+func varyHeaders(h http.Header) ([]string, bool) {
+	seen := make(map[string]struct{})
+
+	var names []string
+	for _, val := range h.Values("Vary") {
+		for _, name := range strings.Split(val, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+
+			name = http.CanonicalHeaderKey(name)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, true
+}
+
+// varyKey returns the key of the variant of key selected by the values of the
+// given request headers.
+func varyKey(key string, names []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	b.WriteString("|Vary")
+
+	for _, name := range names {
+		b.WriteString("|")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+
+	return b.String()
+}
+
+// variants returns the variants listed by the vary manifest of key, with vkey
+// added as stored at now. Above maxVariants, the least recently stored
+// variants are removed from the list and the backend. Concurrent stores of two
+// variants may drop one of them from the list, it then expires with its TTL.
+// It returns nil when the number of variants is not bounded.
+func (m *cache) variants(key, vkey string, vary []string, now time.Time) map[string]time.Time {
+	if m.cfg.MaxVariants <= 0 {
+		return nil
+	}
+
+	variants := make(map[string]time.Time)
+	if manifest, err := m.get(key); err == nil && strings.Join(manifest.Vary, ",") == strings.Join(vary, ",") {
+		for k, stored := range manifest.Variants {
+			variants[k] = stored
+		}
+	}
+	variants[vkey] = now
+
+	for len(variants) > m.cfg.MaxVariants {
+		var oldest string
+		for k, stored := range variants {
+			if k != vkey && (oldest == "" || stored.Before(variants[oldest])) {
+				oldest = k
+			}
+		}
+		delete(variants, oldest)
+
+		if err := m.cache.Delete(oldest); err != nil && !errors.Is(err, errCacheMiss) {
+			m.logger.Error("Error removing cache variant", "key", oldest, "error", err)
+			continue
+		}
+		m.logger.Debug("Removed the oldest cache variant", "key", oldest)
+	}
+
+	return variants
+}
@@ -0,0 +1,164 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVaryHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		vary   []string
+		want   []string
+		wantOK bool
+	}{
+		{
+			name:   "should return nothing without vary",
+			wantOK: true,
+		},
+		{
+			name:   "should canonicalize, dedupe and sort names",
+			vary:   []string{"accept-language, Accept-Encoding", "ACCEPT-ENCODING"},
+			want:   []string{"Accept-Encoding", "Accept-Language"},
+			wantOK: true,
+		},
+		{
+			name:   "should reject a wildcard",
+			vary:   []string{"Accept-Encoding, *"},
+			wantOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			for _, v := range test.vary {
+				h.Add("Vary", v)
+			}
+
+			got, ok := varyHeaders(h)
+			if ok != test.wantOK {
+				t.Fatalf("unexpected ok: want %t, got %t", test.wantOK, ok)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("unexpected names: want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTPVary(t *testing.T) {
+	dir := createTempDir(t)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		encoding := "identity"
+		if req.Header.Get("Accept-Encoding") == "gzip" {
+			encoding = "gzip"
+		}
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Vary", "Accept-Encoding")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(encoding))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		acceptEncoding string
+		wantBody       string
+		wantStatus     string
+	}{
+		{acceptEncoding: "gzip", wantBody: "gzip", wantStatus: "miss"},
+		{acceptEncoding: "", wantBody: "identity", wantStatus: "miss"},
+		{acceptEncoding: "gzip", wantBody: "gzip", wantStatus: "hit"},
+		{acceptEncoding: "", wantBody: "identity", wantStatus: "hit"},
+	}
+
+	for _, test := range requests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if body := rw.Body.String(); body != test.wantBody {
+			t.Errorf("unexpected body for %q: want %q, got %q", test.acceptEncoding, test.wantBody, body)
+		}
+		if state := rw.Header().Get("Cache-Status"); state != test.wantStatus {
+			t.Errorf("unexpected cache state for %q: want %q, got %q", test.acceptEncoding, test.wantStatus, state)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("unexpected next handler calls: want 2, got %d", calls)
+	}
+}
+
+func TestCache_ServeHTTPMaxVariants(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Header().Set("Vary", "User-Agent")
+		_, _ = rw.Write([]byte(req.Header.Get("User-Agent")))
+	}
+
+	cfg := &Config{MaxExpiry: 100, Cleanup: 200, Backend: memoryBackend, MaxVariants: 3}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+	clk := newFakeClock()
+	setClock(c, clk)
+
+	newRequest := func(i int) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("User-Agent", fmt.Sprintf("agent/%d", i))
+		return req
+	}
+
+	for i := 0; i < 10; i++ {
+		c.ServeHTTP(httptest.NewRecorder(), newRequest(i))
+		clk.Advance(time.Second)
+	}
+
+	manifest, err := c.get(c.cacheKey(newRequest(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Variants) != 3 {
+		t.Errorf("unexpected tracked variants: want 3, got %v", manifest.Variants)
+	}
+	if entries, _ := c.cache.(sizer).usage(); entries != 4 {
+		t.Errorf("unexpected stored entries: want the manifest and 3 variants, got %d", entries)
+	}
+
+	calls = 0
+	for i := 7; i < 10; i++ {
+		c.ServeHTTP(httptest.NewRecorder(), newRequest(i))
+	}
+	if calls != 0 {
+		t.Errorf("expected the newest variants to be served from the cache, got %d next handler calls", calls)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), newRequest(0))
+	if calls != 1 {
+		t.Errorf("expected the oldest variant to be removed, got %d next handler calls", calls)
+	}
+}
@@ -0,0 +1,45 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+)
+
+// warmup fetches each of the URLs from the next handler and stores the
+// cacheable responses, so the first requests are served from the cache. URLs
+// are fetched one after another until ctx is done.
+func (m *cache) warmup(ctx context.Context, urls []string) {
+	for _, u := range urls {
+		if ctx.Err() != nil {
+			return
+		}
+
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			m.logger.Error("Error warming up", "url", u, "error", err)
+			continue
+		}
+		req = req.WithContext(ctx)
+
+		key := m.cacheKey(req)
+		m.flight.Do(key, func() {
+			m.warmupKey(req, key)
+		})
+	}
+}
+
+func (m *cache) warmupKey(r *http.Request, key string) {
+	bw := &bufferWriter{header: make(http.Header)}
+	m.forward(bw, r)
+
+	if m.cfg.MaxBodyBytes > 0 && int64(bw.body.Len()) > m.cfg.MaxBodyBytes {
+		m.logger.Debug("Response exceeds the maximum body size", "key", key, "maxBodyBytes", m.cfg.MaxBodyBytes)
+		return
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+
+	m.logger.Debug("Warmed up", "key", key, "status", bw.status)
+	m.store(key, r, bw.status, bw.header, bw.body.Bytes())
+}
@@ -0,0 +1,70 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache_Warmup(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls[req.URL.Path]++
+		mu.Unlock()
+
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(req.URL.Path))
+	}
+
+	urls := []string{"http://localhost/a", "http://localhost/b"}
+
+	cfg := &Config{MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, LegacyStatusHeader: true, Backend: memoryBackend, WarmupURLs: urls}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := New(ctx, http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.(*cache)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, u := range urls {
+		key := c.cacheKey(httptest.NewRequest(http.MethodGet, u, nil))
+		for {
+			if _, err = c.cache.Get(key); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not warmed up", u)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for _, u := range urls {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, u, nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != "hit" {
+			t.Errorf("unexpected cache state for %s: want \"hit\", got: %q", u, state)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, path := range []string{"/a", "/b"} {
+		if calls[path] != 1 {
+			t.Errorf("unexpected next handler calls for %s: want 1, got %d", path, calls[path])
+		}
+	}
+}